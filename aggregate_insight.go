@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+const aggregateDomainID = "bulkinsi-843c-4055-8baa-de52d697335d"
+
+// insightAggregation is one dashboard style aggregation over the insight
+// index. buckets extracts the number of buckets from the response, or the
+// value for a metric aggregation.
+type insightAggregation struct {
+	name    string
+	agg     func(r *rand.Rand) elastic.Aggregation
+	buckets func(aggs elastic.Aggregations) int
+}
+
+func insightAggregations(stateKey []string) []insightAggregation {
+	return []insightAggregation{
+		{
+			name: "terms values of a state key",
+			agg: func(r *rand.Rand) elastic.Aggregation {
+				// state keys are dynamically mapped as text with a keyword sub field
+				k := stateKey[r.Intn(len(stateKey))]
+				return elastic.NewTermsAggregation().Field(k + ".keyword").Size(100)
+			},
+			buckets: func(aggs elastic.Aggregations) int {
+				if res, found := aggs.Terms("agg"); found {
+					return len(res.Buckets)
+				}
+				return 0
+			},
+		},
+		{
+			name: "date_histogram update_time",
+			agg: func(r *rand.Rand) elastic.Aggregation {
+				return elastic.NewDateHistogramAggregation().Field("update_time").Interval("1m")
+			},
+			buckets: func(aggs elastic.Aggregations) int {
+				if res, found := aggs.DateHistogram("agg"); found {
+					return len(res.Buckets)
+				}
+				return 0
+			},
+		},
+		{
+			name: "docs per state",
+			agg: func(r *rand.Rand) elastic.Aggregation {
+				agg := elastic.NewFiltersAggregation()
+				for _, k := range stateKey {
+					agg = agg.FilterWithName(k, elastic.NewExistsQuery(k))
+				}
+				return agg
+			},
+			buckets: func(aggs elastic.Aggregations) int {
+				if res, found := aggs.Filters("agg"); found {
+					return len(res.NamedBuckets)
+				}
+				return 0
+			},
+		},
+		{
+			name: "cardinality values of a state key",
+			agg: func(r *rand.Rand) elastic.Aggregation {
+				k := stateKey[r.Intn(len(stateKey))]
+				return elastic.NewCardinalityAggregation().Field(k + ".keyword")
+			},
+			buckets: func(aggs elastic.Aggregations) int {
+				if res, found := aggs.Cardinality("agg"); found && res.Value != nil {
+					return int(*res.Value)
+				}
+				return 0
+			},
+		},
+	}
+}
+
+type insightAggregationResult struct {
+	name       string
+	errors     int
+	avgTook    int64
+	p50        time.Duration
+	p99        time.Duration
+	avgBuckets int
+}
+
+func runInsightAggregation(ctx context.Context, client *elastic.Client, a insightAggregation, times int) insightAggregationResult {
+	result := insightAggregationResult{name: a.name}
+	var latencies []time.Duration
+	var totalTook int64
+	var totalBuckets int
+	for i := 0; i < times; i++ {
+		millis := time.Now().UnixNano() / 1e6
+		src := rand.NewSource(time.Now().UnixNano())
+		r := rand.New(src)
+
+		rangeQuery := elastic.NewRangeQuery("update_time").Gte(millis - 3600000).Lte(millis)
+		reqStartTime := time.Now()
+		searchResult, err := client.Search().Index(aggregateDomainID).Query(rangeQuery).
+			Aggregation("agg", a.agg(r)).
+			Size(0).
+			Do(ctx)
+		if err != nil {
+			fmt.Println("aggregation failed ", err)
+			result.errors++
+			continue
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+		totalTook += searchResult.TookInMillis
+		totalBuckets += a.buckets(searchResult.Aggregations)
+	}
+	if len(latencies) == 0 {
+		return result
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	n := len(latencies) - 1
+	result.p50 = latencies[n*50/100]
+	result.p99 = latencies[n*99/100]
+	result.avgTook = totalTook / int64(len(latencies))
+	result.avgBuckets = totalBuckets / len(latencies)
+	return result
+}
+
+func main() {
+	var times int
+	fmt.Println("Number of requests per aggregation: ")
+	fmt.Scanln(&times)
+
+	if times <= 0 {
+		times = 100
+	}
+
+	numOfStateKey := 50
+	var stateKey []string
+	for i := 0; i < numOfStateKey; i += 1 {
+		stateKey = append(stateKey, "state_key_"+strconv.Itoa(i))
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("------ Insight Aggregations ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "aggregation\tavg took millis\tp50\tp99\tavg buckets/value\terrors\t")
+	for _, a := range insightAggregations(stateKey) {
+		r := runInsightAggregation(ctx, client, a, times)
+		fmt.Fprintf(w, "%s\t%d\t%v\t%v\t%d\t%d\t\n", r.name, r.avgTook, r.p50, r.p99, r.avgBuckets, r.errors)
+	}
+	w.Flush()
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+const insight_check_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	}
+}`
+
+const checkDomainID = "checkups-843c-4055-8baa-de52d697335d"
+
+// insightShadow is the in-memory expectation of every doc written. It
+// applies partial docs the same way DocAsUpsert(true) does: the first update
+// creates the doc, later ones overwrite only the top level fields they carry.
+type insightShadow struct {
+	lock      sync.Mutex
+	docs      map[string]map[string]interface{}
+	uncertain map[string]bool
+	conflicts int64
+}
+
+func (s *insightShadow) apply(id string, doc map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.docs[id]
+	if !ok {
+		current = make(map[string]interface{})
+		s.docs[id] = current
+	}
+	for k, v := range doc {
+		current[k] = v
+	}
+}
+
+// markUncertain records docs whose update outcome is unknown, e.g. when the
+// whole bulk request failed after it may have been partially applied.
+func (s *insightShadow) markUncertain(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.uncertain[id] = true
+}
+
+func (s *insightShadow) addConflict() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conflicts++
+}
+
+// checkProgress is the status line of the upsert phase. Conflicts are
+// expected in contended mode and do not count as errors.
+type checkProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressCheck = &checkProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *checkProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *checkProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *checkProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// upsertCheckedInsight upserts random states into docs. In contended mode all
+// go routines update the same docs, each with its own set of fields, so the
+// expected end state is still known but updates race on the doc version.
+func upsertCheckedInsight(threadID int, numOfThread int, done *sync.WaitGroup, times, batchSize, numOfDoc int,
+	baseDocID string, stateKey, stateValue []string, shadow *insightShadow, contended bool, retryOnConflict int) {
+	defer done.Done()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	for t := 1; t <= times; t++ {
+		bulkRequest := client.Bulk()
+		var ids []string
+		var docs []map[string]interface{}
+		for i := 0; i < batchSize; i++ {
+			var id string
+			var doc map[string]interface{}
+			k := stateKey[r.Intn(len(stateKey))]
+			v := stateValue[r.Intn(len(stateValue))]
+			if contended {
+				id = baseDocID + strconv.Itoa(r.Intn(numOfDoc))
+				prefix := "t" + strconv.Itoa(threadID) + "_"
+				doc = map[string]interface{}{prefix + k: v, prefix + "update_time": time.Now().UnixNano() / 1e6}
+			} else {
+				// every go routine owns its own docs so the order of updates to a
+				// doc is the order they were generated in
+				id = baseDocID + strconv.Itoa(r.Intn(numOfDoc/numOfThread)*numOfThread+threadID)
+				doc = map[string]interface{}{k: v, "update_time": time.Now().UnixNano() / 1e6}
+			}
+
+			req := elastic.NewBulkUpdateRequest().Index(checkDomainID).Type("_doc").Id(id).Doc(doc).DocAsUpsert(true)
+			if retryOnConflict > 0 {
+				req = req.RetryOnConflict(retryOnConflict)
+			}
+			bulkRequest.Add(req)
+			ids = append(ids, id)
+			docs = append(docs, doc)
+		}
+
+		reqStartTime := time.Now()
+		bulkResponse, err := bulkRequest.Do(context.Background())
+		if err != nil {
+			progressCheck.record(time.Since(reqStartTime), 0, batchSize)
+			fmt.Println("bulk failed", err)
+			for _, id := range ids {
+				shadow.markUncertain(id)
+			}
+			continue
+		}
+
+		reqTime := time.Since(reqStartTime)
+		// bulk items come back in request order
+		applied, failed := 0, 0
+		for i, item := range bulkResponse.Items {
+			for _, res := range item {
+				if res.Status >= 200 && res.Status < 300 {
+					shadow.apply(ids[i], docs[i])
+					applied++
+				} else if res.Status == 409 {
+					shadow.addConflict()
+				} else {
+					fmt.Println("update failed ", ids[i], " status: ", res.Status)
+					failed++
+				}
+			}
+		}
+		progressCheck.record(reqTime, applied, failed)
+	}
+}
+
+// normalizeShadowDoc round trips a shadow doc through JSON so that it
+// compares equal to a source decoded from Elasticsearch.
+func normalizeShadowDoc(doc map[string]interface{}) map[string]interface{} {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		panic(err)
+	}
+	return normalized
+}
+
+// checkInsightEndState scrolls over the whole index and compares it with the
+// shadow. It returns the number of missing, extra and mismatched docs, and
+// the number of acknowledged field updates that are not in the index.
+func checkInsightEndState(ctx context.Context, client *elastic.Client, shadow *insightShadow) (int, int, int, int) {
+	seen := make(map[string]bool)
+	extra, mismatched, lostFields := 0, 0, 0
+
+	scroll := client.Scroll().Index(checkDomainID).Size(1000)
+	defer scroll.Clear(ctx)
+	for {
+		results, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		for _, hit := range results.Hits.Hits {
+			seen[hit.Id] = true
+			expected, ok := shadow.docs[hit.Id]
+			if !ok {
+				if !shadow.uncertain[hit.Id] {
+					extra++
+					fmt.Println("extra doc ", hit.Id)
+				}
+				continue
+			}
+			var source map[string]interface{}
+			if err := json.Unmarshal(*hit.Source, &source); err != nil {
+				panic(err)
+			}
+			normalized := normalizeShadowDoc(expected)
+			if !reflect.DeepEqual(source, normalized) && !shadow.uncertain[hit.Id] {
+				mismatched++
+				if mismatched <= 10 {
+					fmt.Println("mismatched doc ", hit.Id, " expected: ", expected, " actual: ", source)
+				}
+				for k, v := range normalized {
+					if !reflect.DeepEqual(source[k], v) {
+						lostFields++
+					}
+				}
+			}
+		}
+	}
+
+	missing := 0
+	for id := range shadow.docs {
+		if !seen[id] && !shadow.uncertain[id] {
+			missing++
+			if missing <= 10 {
+				fmt.Println("missing doc ", id)
+			}
+		}
+	}
+	return missing, extra, mismatched, lostFields
+}
+
+func main() {
+	var numOfThread int
+	fmt.Println("Number of go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	var numOfRequestPerThread int
+	fmt.Println("Number of request per go routines: ")
+	fmt.Scanln(&numOfRequestPerThread)
+
+	var bulkSize int
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var numOfDoc int
+	fmt.Println("Number of docs: ")
+	fmt.Scanln(&numOfDoc)
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	var contendedMode string
+	fmt.Println("Contended mode, all go routines update the same docs (y/n): ")
+	fmt.Scanln(&contendedMode)
+	contended := contendedMode == "y"
+
+	var retryOnConflict int
+	fmt.Println("Retry on conflict (0 for none): ")
+	fmt.Scanln(&retryOnConflict)
+
+	if numOfDoc < numOfThread {
+		numOfDoc = 10000
+	}
+
+	numOfStateKey := 50
+	numOfStateValue := 100
+	var stateKey []string
+	var stateValue []string
+	for i := 0; i < numOfStateKey; i += 1 {
+		stateKey = append(stateKey, "state_key_"+strconv.Itoa(i))
+	}
+	for i := 0; i < numOfStateValue; i += 1 {
+		stateValue = append(stateValue, "state_value_"+strconv.Itoa(i))
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(checkDomainID).Do(ctx)
+	if exists {
+		// a fresh index is needed to tell extra docs apart
+		fmt.Println("delete index ", checkDomainID)
+		if _, err := client.DeleteIndex(checkDomainID).Do(ctx); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Println("create index ", checkDomainID)
+	createIndex, err := client.CreateIndex(checkDomainID).BodyString(insight_check_setting).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !createIndex.Acknowledged {
+		// Not acknowledged
+	}
+
+	shadow := &insightShadow{
+		docs:      make(map[string]map[string]interface{}),
+		uncertain: make(map[string]bool),
+	}
+	baseDocID := uuid.New() + "_"
+
+	progressCheck.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressCheck.show(stopProgress, &progress)
+	var done sync.WaitGroup
+	done.Add(numOfThread)
+	startTime := time.Now()
+	for i := 0; i < numOfThread; i += 1 {
+		go upsertCheckedInsight(i, numOfThread, &done, numOfRequestPerThread, bulkSize, numOfDoc, baseDocID, stateKey, stateValue, shadow, contended, retryOnConflict)
+	}
+	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+	fmt.Println("write time: ", time.Since(startTime))
+
+	if _, err := client.Refresh(checkDomainID).Do(ctx); err != nil {
+		panic(err)
+	}
+	missing, extra, mismatched, lostFields := checkInsightEndState(ctx, client, shadow)
+
+	fmt.Println("------ End State Check ------")
+	fmt.Println("expected docs: ", len(shadow.docs))
+	fmt.Println("uncertain docs (skipped): ", len(shadow.uncertain))
+	fmt.Println("missing: ", missing)
+	fmt.Println("extra: ", extra)
+	fmt.Println("mismatched: ", mismatched)
+	fmt.Println("lost updates (acknowledged fields not in index): ", lostFields)
+	fmt.Println("version conflicts (retry on conflict ", retryOnConflict, "): ", shadow.conflicts)
+}
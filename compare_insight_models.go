@@ -0,0 +1,714 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+const insight_model_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	}
+}`
+
+// the wide model adds a field per state key. Dynamic strings are mapped as a
+// single keyword field instead of text with a keyword sub field, and the
+// fields limit is raised above the number of state keys, otherwise most wide
+// writes are rejected once the default limit of 1000 fields is reached.
+const insight_wide_model_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1,
+		"index.mapping.total_fields.limit": %d
+	},
+	"mappings":{
+		"_doc":{
+			"dynamic_templates":[
+				{
+					"strings_as_keywords":{
+						"match_mapping_type": "string",
+						"mapping":{
+							"type": "keyword"
+						}
+					}
+				}
+			]
+		}
+	}
+}`
+
+// the nested model keeps the states of an entity in a nested array, so its
+// mapping stays fixed however many state keys there are
+const insight_nested_model_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	},
+	"mappings":{
+		"_doc":{
+			"properties":{
+				"update_time":{
+					"type":"long"
+				},
+				"states":{
+					"type":"nested",
+					"properties":{
+						"key":{
+							"type":"keyword"
+						},
+						"value":{
+							"type":"keyword"
+						},
+						"update_time":{
+							"type":"long"
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// same script as update_insight_nested.go, replaces the state with the same
+// key in the nested array or appends it
+const insight_nested_model_upsert_script = `
+boolean found = false;
+for (def s : ctx._source.states) {
+	if (s.key == params.key) {
+		s.value = params.value;
+		s.update_time = params.update_time;
+		found = true;
+		break;
+	}
+}
+if (!found) {
+	ctx._source.states.add(['key': params.key, 'value': params.value, 'update_time': params.update_time]);
+}
+ctx._source.update_time = params.update_time;
+`
+
+// one doc per entity with a field per state, like update_insight_bulk.go
+const wideModelDomainID = "modelwid-843c-4055-8baa-de52d697335d"
+
+// one doc per (entity, state), like update_insight_bulk2.go
+const narrowModelDomainID = "modelnar-843c-4055-8baa-de52d697335d"
+
+// one doc per entity with a nested doc per state, like update_insight_nested.go
+const nestedModelDomainID = "modelnes-843c-4055-8baa-de52d697335d"
+
+var modelStateKeys []string
+var modelStateValues [][]string
+var modelBaseDocID string
+var modelNumOfStates int
+var modelNumOfValues int
+var modelNumOfDoc int
+var modelNumOfStatesPerDoc int
+
+// insightEvent is one state change of an entity. The same events are
+// written to every model.
+type insightEvent struct {
+	entityID string
+	key      string
+	value    string
+	millis   int64
+}
+
+// insightModelStats is the ingest side of one model.
+type insightModelStats struct {
+	lock       sync.Mutex
+	reqUsed    time.Duration
+	bulkTook   int64
+	numOfBulk  int64
+	events     int64
+	indexed    int64
+	failed     int64
+	conflicted int64
+}
+
+// modelProgress is the status line of the load phase, fed by the bulks of
+// every model alike. Version conflicts count neither as docs nor as errors.
+type modelProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressModel = &modelProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *modelProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *modelProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *modelProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+func (s *insightModelStats) add(reqUsed time.Duration, res *elastic.BulkResponse, events int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reqUsed += reqUsed
+	s.numOfBulk++
+	s.events += int64(events)
+	if res == nil {
+		s.failed += int64(events)
+		progressModel.record(reqUsed, 0, events)
+		return
+	}
+	s.bulkTook += int64(res.Took)
+	failed, conflicted := 0, 0
+	for _, item := range res.Failed() {
+		// the narrow model rejects stale versions by design, the nested model
+		// gives up on an entity updated concurrently after its retries
+		if item.Status == 409 {
+			conflicted++
+		} else {
+			failed++
+		}
+	}
+	s.indexed += int64(events - failed - conflicted)
+	s.failed += int64(failed)
+	s.conflicted += int64(conflicted)
+	progressModel.record(reqUsed, events-failed-conflicted, failed)
+}
+
+func generateInsightEvents(r *rand.Rand, batchSize int) []insightEvent {
+	var events []insightEvent
+	for i := 0; i < batchSize; i++ {
+		entityID := modelBaseDocID + strconv.Itoa(r.Intn(modelNumOfDoc))
+		keyIndex := getModelKeyIndex(entityID, r.Intn(modelNumOfStatesPerDoc))
+		events = append(events, insightEvent{
+			entityID: entityID,
+			key:      modelStateKeys[keyIndex],
+			value:    modelStateValues[keyIndex][r.Intn(modelNumOfValues)],
+			millis:   time.Now().UnixNano() / 1e6,
+		})
+	}
+	return events
+}
+
+func writeWideModel(client *elastic.Client, events []insightEvent, stats *insightModelStats) {
+	bulkRequest := client.Bulk()
+	for _, e := range events {
+		doc := map[string]interface{}{e.key: e.value, "update_time": e.millis}
+		req := elastic.NewBulkUpdateRequest().Index(wideModelDomainID).Type("_doc").Id(e.entityID).Doc(doc).DocAsUpsert(true)
+		bulkRequest.Add(req)
+	}
+
+	reqStartTime := time.Now()
+	bulkResponse, err := bulkRequest.Do(context.Background())
+	if err != nil {
+		fmt.Println("wide bulk failed", err)
+	}
+	stats.add(time.Since(reqStartTime), bulkResponse, len(events))
+}
+
+func writeNarrowModel(client *elastic.Client, events []insightEvent, stats *insightModelStats) {
+	bulkRequest := client.Bulk()
+	for _, e := range events {
+		// entity is not in update_insight_bulk2.go, it is needed for the
+		// "all states of an entity" read query
+		doc := map[string]interface{}{"entity": e.entityID, "state": e.key, "value": e.value, "update_time": e.millis}
+		req := elastic.NewBulkIndexRequest().Index(narrowModelDomainID).Type("_doc").Id(e.entityID + "_" + e.key).Doc(doc).
+			VersionType("external").Version(e.millis)
+		bulkRequest.Add(req)
+	}
+
+	reqStartTime := time.Now()
+	bulkResponse, err := bulkRequest.Do(context.Background())
+	if err != nil {
+		fmt.Println("narrow bulk failed", err)
+	}
+	stats.add(time.Since(reqStartTime), bulkResponse, len(events))
+}
+
+func writeNestedModel(client *elastic.Client, events []insightEvent, stats *insightModelStats) {
+	bulkRequest := client.Bulk()
+	for _, e := range events {
+		state := map[string]interface{}{"key": e.key, "value": e.value, "update_time": e.millis}
+		script := elastic.NewScript(insight_nested_model_upsert_script).Params(state)
+		upsert := map[string]interface{}{"states": []interface{}{state}, "update_time": e.millis}
+		req := elastic.NewBulkUpdateRequest().Index(nestedModelDomainID).Type("_doc").Id(e.entityID).
+			Script(script).Upsert(upsert).RetryOnConflict(3)
+		bulkRequest.Add(req)
+	}
+
+	reqStartTime := time.Now()
+	bulkResponse, err := bulkRequest.Do(context.Background())
+	if err != nil {
+		fmt.Println("nested bulk failed", err)
+	}
+	stats.add(time.Since(reqStartTime), bulkResponse, len(events))
+}
+
+func loadInsightModels(threadID string, done *sync.WaitGroup, times, batchSize int, wide, narrow, nested *insightModelStats) {
+	defer done.Done()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	for t := 1; t <= times; t++ {
+		events := generateInsightEvents(r, batchSize)
+		writeWideModel(client, events, wide)
+		writeNarrowModel(client, events, narrow)
+		writeNestedModel(client, events, nested)
+	}
+}
+
+// countMappingFields counts the leaf fields of a mapping, including multi
+// fields such as the keyword sub field of dynamic strings.
+func countMappingFields(properties map[string]interface{}) int {
+	count := 0
+	for _, v := range properties {
+		field, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if sub, ok := field["properties"].(map[string]interface{}); ok {
+			count += countMappingFields(sub)
+		} else {
+			count++
+		}
+		if sub, ok := field["fields"].(map[string]interface{}); ok {
+			count += countMappingFields(sub)
+		}
+	}
+	return count
+}
+
+func indexFieldCount(ctx context.Context, client *elastic.Client, index string) int {
+	res, err := client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		fmt.Println("get mapping failed ", err)
+		return -1
+	}
+	count := 0
+	for _, i := range res {
+		mappings, _ := i.(map[string]interface{})["mappings"].(map[string]interface{})
+		for _, t := range mappings {
+			if properties, ok := t.(map[string]interface{})["properties"].(map[string]interface{}); ok {
+				count += countMappingFields(properties)
+			}
+		}
+	}
+	return count
+}
+
+func indexDocsAndSize(ctx context.Context, client *elastic.Client, index string) (int64, int64) {
+	stats, err := client.IndexStats(index).Do(ctx)
+	if err != nil {
+		fmt.Println("index stats failed ", err)
+		return -1, -1
+	}
+	s, ok := stats.Indices[index]
+	if !ok || s.Primaries == nil || s.Primaries.Docs == nil || s.Total == nil || s.Total.Store == nil {
+		return -1, -1
+	}
+	return s.Primaries.Docs.Count, s.Total.Store.SizeInBytes
+}
+
+// timeModelQuery runs query times and returns the p50 and p99 latency.
+func timeModelQuery(times int, query func(r *rand.Rand) error) (time.Duration, time.Duration) {
+	var latencies []time.Duration
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	for i := 0; i < times; i++ {
+		reqStartTime := time.Now()
+		if err := query(r); err != nil {
+			fmt.Println("query failed ", err)
+			continue
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+	}
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	n := len(latencies) - 1
+	return latencies[n*50/100], latencies[n*99/100]
+}
+
+func randomModelState(r *rand.Rand) (string, string, string) {
+	entityID := modelBaseDocID + strconv.Itoa(r.Intn(modelNumOfDoc))
+	keyIndex := getModelKeyIndex(entityID, r.Intn(modelNumOfStatesPerDoc))
+	return entityID, modelStateKeys[keyIndex], modelStateValues[keyIndex][r.Intn(modelNumOfValues)]
+}
+
+// waitForModelQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForModelQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
+		if err != nil {
+			return err
+		}
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func main() {
+	var numOfThread int
+	fmt.Println("Number of go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	var numOfRequestPerThread int
+	fmt.Println("Number of request per go routines: ")
+	fmt.Scanln(&numOfRequestPerThread)
+
+	var bulkSize int
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var numOfReads int
+	fmt.Println("Number of read requests per query: ")
+	fmt.Scanln(&numOfReads)
+
+	var waitForStatus string
+	fmt.Println("Wait for health before start (green/yellow): ")
+	fmt.Scanln(&waitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before start (y/n): ")
+	fmt.Scanln(&waitForMerges)
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	if numOfReads <= 0 {
+		numOfReads = 100
+	}
+
+	if waitForStatus != "green" {
+		waitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+
+	initModelData()
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	settings := map[string]string{
+		wideModelDomainID:   fmt.Sprintf(insight_wide_model_setting, modelNumOfStates+100),
+		narrowModelDomainID: insight_model_setting,
+		nestedModelDomainID: insight_nested_model_setting,
+	}
+	for _, index := range []string{wideModelDomainID, narrowModelDomainID, nestedModelDomainID} {
+		exists, err := client.IndexExists(index).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if exists {
+			fmt.Println("delete index ", index)
+			if _, err := client.DeleteIndex(index).Do(ctx); err != nil {
+				panic(err)
+			}
+		}
+		fmt.Println("create index ", index)
+		createIndex, err := client.CreateIndex(index).BodyString(settings[index]).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	err = waitForModelQuiescence(client, []string{wideModelDomainID, narrowModelDomainID, nestedModelDomainID}, waitForStatus, waitForMerges == "y", time.Duration(preflightTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	var wide, narrow, nested insightModelStats
+	progressModel.start(int64(3 * numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressModel.show(stopProgress, &progress)
+	var done sync.WaitGroup
+	done.Add(numOfThread)
+	for i := 0; i < numOfThread; i += 1 {
+		go loadInsightModels(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &wide, &narrow, &nested)
+	}
+	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+
+	if _, err := client.Refresh(wideModelDomainID, narrowModelDomainID, nestedModelDomainID).Do(ctx); err != nil {
+		panic(err)
+	}
+
+	wideDocs, wideSize := indexDocsAndSize(ctx, client, wideModelDomainID)
+	narrowDocs, narrowSize := indexDocsAndSize(ctx, client, narrowModelDomainID)
+	// the docs of the nested index include a hidden doc per nested state
+	nestedDocs, nestedSize := indexDocsAndSize(ctx, client, nestedModelDomainID)
+	wideFields := indexFieldCount(ctx, client, wideModelDomainID)
+	narrowFields := indexFieldCount(ctx, client, narrowModelDomainID)
+	nestedFields := indexFieldCount(ctx, client, nestedModelDomainID)
+
+	// entities in a state updated within the last hour
+	wideByStateP50, wideByStateP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		_, k, v := randomModelState(r)
+		millis := time.Now().UnixNano() / 1e6
+		query := elastic.NewBoolQuery().
+			Must(elastic.NewMatchPhraseQuery(k, v)).
+			Filter(elastic.NewRangeQuery("update_time").Gte(millis - 3600000).Lte(millis))
+		_, err := client.Search().Index(wideModelDomainID).Query(query).Sort("update_time", false).Size(10).Do(ctx)
+		return err
+	})
+	narrowByStateP50, narrowByStateP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		_, k, v := randomModelState(r)
+		millis := time.Now().UnixNano() / 1e6
+		query := elastic.NewBoolQuery().
+			Must(elastic.NewMatchPhraseQuery("state", k), elastic.NewMatchPhraseQuery("value", v)).
+			Filter(elastic.NewRangeQuery("update_time").Gte(millis - 3600000).Lte(millis))
+		_, err := client.Search().Index(narrowModelDomainID).Query(query).Sort("update_time", false).Size(10).Do(ctx)
+		return err
+	})
+	nestedByStateP50, nestedByStateP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		_, k, v := randomModelState(r)
+		millis := time.Now().UnixNano() / 1e6
+		query := elastic.NewNestedQuery("states", elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("states.key", k)).
+			Filter(elastic.NewTermQuery("states.value", v)).
+			Filter(elastic.NewRangeQuery("states.update_time").Gte(millis-3600000).Lte(millis)))
+		_, err := client.Search().Index(nestedModelDomainID).Query(query).Sort("update_time", false).Size(10).Do(ctx)
+		return err
+	})
+
+	// all states of one entity
+	wideByEntityP50, wideByEntityP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		entityID, _, _ := randomModelState(r)
+		_, err := client.Get().Index(wideModelDomainID).Type("_doc").Id(entityID).Do(ctx)
+		if elastic.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	narrowByEntityP50, narrowByEntityP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		entityID, _, _ := randomModelState(r)
+		query := elastic.NewBoolQuery().Filter(elastic.NewTermQuery("entity.keyword", entityID))
+		_, err := client.Search().Index(narrowModelDomainID).Query(query).Size(modelNumOfStatesPerDoc).Do(ctx)
+		return err
+	})
+	nestedByEntityP50, nestedByEntityP99 := timeModelQuery(numOfReads, func(r *rand.Rand) error {
+		entityID, _, _ := randomModelState(r)
+		_, err := client.Get().Index(nestedModelDomainID).Type("_doc").Id(entityID).Do(ctx)
+		if elastic.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+
+	wideTime := time.Duration(int64(wide.reqUsed) / int64(numOfThread))
+	narrowTime := time.Duration(int64(narrow.reqUsed) / int64(numOfThread))
+	nestedTime := time.Duration(int64(nested.reqUsed) / int64(numOfThread))
+
+	fmt.Println("------ Insight Model Comparison ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\twide (doc per entity)\tnarrow (doc per state)\tnested (doc per entity, nested states)\t")
+	fmt.Fprintf(w, "events\t%d\t%d\t%d\t\n", wide.events, narrow.events, nested.events)
+	fmt.Fprintf(w, "avg time on request\t%v\t%v\t%v\t\n", wideTime, narrowTime, nestedTime)
+	fmt.Fprintf(w, "indexed events\t%d\t%d\t%d\t\n", wide.indexed, narrow.indexed, nested.indexed)
+	fmt.Fprintf(w, "indexed events/sec\t%.0f\t%.0f\t%.0f\t\n", float64(wide.indexed)/wideTime.Seconds(),
+		float64(narrow.indexed)/narrowTime.Seconds(), float64(nested.indexed)/nestedTime.Seconds())
+	fmt.Fprintf(w, "avg bulk took\t%d\t%d\t%d\t\n", wide.bulkTook/wide.numOfBulk, narrow.bulkTook/narrow.numOfBulk, nested.bulkTook/nested.numOfBulk)
+	fmt.Fprintf(w, "failed items\t%d\t%d\t%d\t\n", wide.failed, narrow.failed, nested.failed)
+	fmt.Fprintf(w, "version conflicts\t%d\t%d\t%d\t\n", wide.conflicted, narrow.conflicted, nested.conflicted)
+	fmt.Fprintf(w, "docs\t%d\t%d\t%d\t\n", wideDocs, narrowDocs, nestedDocs)
+	fmt.Fprintf(w, "store bytes\t%d\t%d\t%d\t\n", wideSize, narrowSize, nestedSize)
+	fmt.Fprintf(w, "mapped fields\t%d\t%d\t%d\t\n", wideFields, narrowFields, nestedFields)
+	fmt.Fprintf(w, "by state p50\t%v\t%v\t%v\t\n", wideByStateP50, narrowByStateP50, nestedByStateP50)
+	fmt.Fprintf(w, "by state p99\t%v\t%v\t%v\t\n", wideByStateP99, narrowByStateP99, nestedByStateP99)
+	fmt.Fprintf(w, "by entity p50\t%v\t%v\t%v\t\n", wideByEntityP50, narrowByEntityP50, nestedByEntityP50)
+	fmt.Fprintf(w, "by entity p99\t%v\t%v\t%v\t\n", wideByEntityP99, narrowByEntityP99, nestedByEntityP99)
+	w.Flush()
+}
+
+func initModelData() {
+	modelBaseDocID = uuid.New()
+	modelBaseDocID = modelBaseDocID + "_" + reverseModel(modelBaseDocID) + "_"
+
+	modelNumOfStatesPerDoc = 50
+
+	modelNumOfStates = 5000
+	modelNumOfValues = 100
+	modelNumOfDoc = 1000000 // 1M
+	for i := 0; i < modelNumOfStates; i++ {
+		modelStateKeys = append(modelStateKeys, uuid.New())
+
+		var values []string
+		for j := 0; j < modelNumOfValues; j++ {
+			values = append(values, uuid.New())
+		}
+		modelStateValues = append(modelStateValues, values)
+	}
+}
+
+func reverseModel(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < len(r)/2; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func getModelKeyIndex(uid string, offset int) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(uid))
+	hash := h.Sum32()
+
+	n := uint32(modelNumOfStates)
+	return (hash%n + uint32(offset)) % n
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// compareRunReport is the part of a run report that is compared.
+type compareRunReport struct {
+	Workload   string                 `json:"workload"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Summary    map[string]float64     `json:"summary"`
+}
+
+// higherIsBetter tells which direction of a summary metric is a regression.
+// Throughput metrics end with _per_sec, latencies with _ms, error counts with
+// _errors and error rates with _pct. Anything else is shown but never fails
+// the comparison.
+func higherIsBetter(metric string) (bool, bool) {
+	if strings.HasSuffix(metric, "_per_sec") {
+		return true, true
+	}
+	if strings.HasSuffix(metric, "_ms") || strings.HasSuffix(metric, "_errors") || strings.HasSuffix(metric, "_pct") {
+		return false, true
+	}
+	return false, false
+}
+
+// perRunParameters differ between reports of the same workload, such as the
+// trial number of a repeated bulk run, and are not warned about.
+var perRunParameters = map[string]bool{
+	"trial": true,
+}
+
+func readCompareReport(filename string) compareRunReport {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	var report compareRunReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		panic(err)
+	}
+	return report
+}
+
+// comparisonMetrics are the summary metrics the baseline and every other
+// report have in common, throughput first then latencies.
+func comparisonMetrics(reports []compareRunReport) []string {
+	var metrics []string
+	for metric := range reports[0].Summary {
+		inAll := true
+		for _, r := range reports[1:] {
+			if _, ok := r.Summary[metric]; !ok {
+				inAll = false
+			}
+		}
+		if inAll {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		iHigher, _ := higherIsBetter(metrics[i])
+		jHigher, _ := higherIsBetter(metrics[j])
+		if iHigher != jHigher {
+			return iHigher
+		}
+		return metrics[i] < metrics[j]
+	})
+	return metrics
+}
+
+func main() {
+	threshold := flag.Float64("threshold", 10, "percent a metric may get worse than the baseline before it is a regression")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go run compare_reports.go [-threshold percent] baseline.json report.json [report.json ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var reports []compareRunReport
+	for _, filename := range flag.Args() {
+		reports = append(reports, readCompareReport(filename))
+	}
+	for i, r := range reports[1:] {
+		if r.Workload != reports[0].Workload {
+			fmt.Printf("warning: %s is workload %s, baseline is %s\n", flag.Arg(i+1), r.Workload, reports[0].Workload)
+		}
+		for k, v := range reports[0].Parameters {
+			if perRunParameters[k] {
+				continue
+			}
+			if fmt.Sprint(r.Parameters[k]) != fmt.Sprint(v) {
+				fmt.Printf("warning: %s has %s %v, baseline has %v\n", flag.Arg(i+1), k, r.Parameters[k], v)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "metric\tbaseline\t")
+	for i := 1; i < len(reports); i++ {
+		fmt.Fprintf(w, "%s\tdelta\t", flag.Arg(i))
+	}
+	fmt.Fprintln(w)
+
+	var regressions []string
+	for _, metric := range comparisonMetrics(reports) {
+		higher, gated := higherIsBetter(metric)
+		base := reports[0].Summary[metric]
+		fmt.Fprintf(w, "%s\t%.2f\t", metric, base)
+		for i, r := range reports[1:] {
+			value := r.Summary[metric]
+			if base == 0 {
+				fmt.Fprintf(w, "%.2f\t-\t", value)
+				if gated && !higher && value > 0 {
+					regressions = append(regressions, fmt.Sprintf("%s: %s %.2f, baseline 0", flag.Arg(i+1), metric, value))
+				}
+				continue
+			}
+			delta := (value - base) / base * 100
+			worse := delta
+			if higher {
+				worse = -delta
+			}
+			mark := ""
+			if gated && worse > *threshold {
+				mark = " REGRESSION"
+				regressions = append(regressions, fmt.Sprintf("%s: %s %.2f, baseline %.2f (%+.1f%%)", flag.Arg(i+1), metric, value, base, delta))
+			}
+			fmt.Fprintf(w, "%.2f\t%+.1f%%%s\t", value, delta, mark)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Println("------ Compare to ", flag.Arg(0), " ------")
+	w.Flush()
+
+	if len(regressions) > 0 {
+		fmt.Printf("%d regressions over %.1f%%:\n", len(regressions), *threshold)
+		for _, r := range regressions {
+			fmt.Println("  ", r)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("no regressions over ", *threshold, "%")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+const countDomainID = "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+const countWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+// countFilter is one CountWorkflowExecutions style filter. query builds the
+// filter for the given now in millis.
+type countFilter struct {
+	name  string
+	query func(millis int64) elastic.Query
+}
+
+func countFilters() []countFilter {
+	return []countFilter{
+		{"all", func(millis int64) elastic.Query {
+			return elastic.NewMatchAllQuery()
+		}},
+		{"workflow type", func(millis int64) elastic.Query {
+			return elastic.NewMatchQuery("workflow_type_name", countWorkflowTypeName)
+		}},
+		{"unknown workflow type", func(millis int64) elastic.Query {
+			return elastic.NewMatchQuery("workflow_type_name", "unknown.workflowType")
+		}},
+		{"status completed", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewTermQuery("status", 0))
+		}},
+		{"status failed", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewTermQuery("status", 1))
+		}},
+		{"closed last 1m", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("close_time").Gte(millis - 60000).Lte(millis))
+		}},
+		{"closed last 1h", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("close_time").Gte(millis - 3600000).Lte(millis))
+		}},
+		{"closed last 24h", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("close_time").Gte(millis - 86400000).Lte(millis))
+		}},
+		{"type and closed last 1h", func(millis int64) elastic.Query {
+			matchQuery := elastic.NewMatchQuery("workflow_type_name", countWorkflowTypeName)
+			rangeQuery := elastic.NewRangeQuery("close_time").Gte(millis - 3600000).Lte(millis)
+			return elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+		}},
+		// history_length stands in for a numeric search attribute
+		{"attribute history_length >= 1024", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("history_length").Gte(1024))
+		}},
+		{"attribute history_length > 1024", func(millis int64) elastic.Query {
+			return elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("history_length").Gt(1024))
+		}},
+	}
+}
+
+type countResult struct {
+	name   string
+	count  int64
+	errors int
+	p50    time.Duration
+	p90    time.Duration
+	p99    time.Duration
+	max    time.Duration
+}
+
+func runCountFilter(ctx context.Context, client *elastic.Client, f countFilter, times int) countResult {
+	result := countResult{name: f.name}
+	var latencies []time.Duration
+	for i := 0; i < times; i++ {
+		millis := time.Now().UnixNano() / 1e6
+		reqStartTime := time.Now()
+		count, err := client.Count(countDomainID).Query(f.query(millis)).Do(ctx)
+		if err != nil {
+			fmt.Println("count failed ", err)
+			result.errors++
+			continue
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+		result.count = count
+	}
+	if len(latencies) == 0 {
+		return result
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	n := len(latencies) - 1
+	result.p50 = latencies[n*50/100]
+	result.p90 = latencies[n*90/100]
+	result.p99 = latencies[n*99/100]
+	result.max = latencies[n]
+	return result
+}
+
+func main() {
+	var times int
+	fmt.Println("Number of requests per filter: ")
+	fmt.Scanln(&times)
+
+	if times <= 0 {
+		times = 100
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	total, err := client.Count(countDomainID).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("total docs: ", total)
+
+	var results []countResult
+	for _, f := range countFilters() {
+		results = append(results, runCountFilter(ctx, client, f, times))
+	}
+
+	// most selective filters first
+	sort.SliceStable(results, func(i, j int) bool { return results[i].count < results[j].count })
+
+	fmt.Println("------ Count Visibility ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "filter\tcount\tselectivity\tp50\tp90\tp99\tmax\terrors\t")
+	for _, r := range results {
+		selectivity := 0.0
+		if total > 0 {
+			selectivity = float64(r.count) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f%%\t%v\t%v\t%v\t%v\t%d\t\n",
+			r.name, r.count, selectivity, r.p50, r.p90, r.p99, r.max, r.errors)
+	}
+	w.Flush()
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+type RetentionWorkflow struct {
+	WorkflowID       string `json:"workflow_id"`
+	RunID            string `json:"run_id"`
+	WorkflowTypeName string `json:"workflow_type_name"`
+	Status           int    `json:"status"`
+	StartTime        int64  `json:"start_time"`
+	CloseTime        int64  `json:"close_time"`
+	HistoryLength    int    `json:"history_length"`
+	Info             string `json:"info,omitempty"`
+}
+
+const retention_index_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	}
+}`
+
+const retentionDomainID = "retentn0-69f9-4495-a1b2-6ea71b5fa459"
+const retentionWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+const dayInMillis = int64(24 * time.Hour / time.Millisecond)
+
+// preflight of every phase, see waitForRetentionQuiescence
+var retentionWaitForStatus string
+var retentionWaitForMerges bool
+var retentionPreflightTimeout time.Duration
+
+// retentionTimelinePoint is what one operation type did within one window of
+// the run, like TimelinePoint in insert_visibility_bulk.go.
+type retentionTimelinePoint struct {
+	OffsetSec float64 `json:"offset_sec"`
+	Operation string  `json:"operation"`
+	Ops       int64   `json:"ops"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	Errors    int64   `json:"errors"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+type retentionTimelineBucket struct {
+	latencies []time.Duration
+	errors    int64
+}
+
+// retentionTimeline buckets every request by operation type and by the window
+// it finished in.
+type retentionTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*retentionTimelineBucket
+}
+
+var timelineRetention = &retentionTimeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *retentionTimeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*retentionTimelineBucket)
+}
+
+func (t *retentionTimeline) record(op string, latency time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*retentionTimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &retentionTimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	if err != nil {
+		b.errors++
+	}
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *retentionTimeline) points() []retentionTimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []retentionTimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := retentionTimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = retentionPercentileMs(b.latencies, 50)
+				p.P90Ms = retentionPercentileMs(b.latencies, 90)
+				p.P99Ms = retentionPercentileMs(b.latencies, 99)
+				p.MaxMs = retentionPercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func retentionPercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// retentionRunReport is written as JSON at the end of the phases in the layout
+// of the insert_visibility_bulk.go run report, so report_html.go can show it.
+type retentionRunReport struct {
+	Workload   string                   `json:"workload"`
+	StartTime  time.Time                `json:"start_time"`
+	Parameters map[string]interface{}   `json:"parameters"`
+	Timeline   []retentionTimelinePoint `json:"timeline"`
+}
+
+func writeRetentionReport(report retentionRunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+// retentionLatency accumulates latency of the background reads or writes
+// issued while a retention cleanup is running.
+type retentionLatency struct {
+	lock   sync.Mutex
+	count  int64
+	errors int64
+	total  time.Duration
+	max    time.Duration
+}
+
+func (l *retentionLatency) record(d time.Duration, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err != nil {
+		l.errors++
+		return
+	}
+	l.count++
+	l.total += d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+func (l *retentionLatency) avg() time.Duration {
+	if l.count == 0 {
+		return 0
+	}
+	return time.Duration(int64(l.total) / l.count)
+}
+
+func dailyIndexName(day int) string {
+	return retentionDomainID + "-day-" + strconv.Itoa(day)
+}
+
+func recreateIndex(ctx context.Context, client *elastic.Client, index string) {
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		fmt.Println("delete index ", index)
+		if _, err := client.DeleteIndex(index).Do(ctx); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Println("create index ", index)
+	createIndex, err := client.CreateIndex(index).BodyString(retention_index_setting).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !createIndex.Acknowledged {
+		// Not acknowledged
+	}
+}
+
+// loadRetentionDay writes numOfDoc closed workflows whose close_time falls
+// into the given day after base.
+func loadRetentionDay(ctx context.Context, client *elastic.Client, index string, base int64, day, numOfDoc, batchSize int) {
+	dayStart := base + int64(day)*dayInMillis
+	step := dayInMillis / int64(numOfDoc)
+
+	bulkRequest := client.Bulk()
+	for i := 0; i < numOfDoc; i++ {
+		rid := uuid.New()
+		closeTime := dayStart + int64(i)*step
+		body := RetentionWorkflow{
+			WorkflowID:       rid,
+			RunID:            rid,
+			WorkflowTypeName: retentionWorkflowTypeName,
+			Status:           0,
+			StartTime:        closeTime - 3600,
+			CloseTime:        closeTime,
+			HistoryLength:    1024,
+			Info:             "some info",
+		}
+		req := elastic.NewBulkIndexRequest().Index(index).Type("_doc").Id(rid + "_" + rid).Doc(body)
+		bulkRequest.Add(req)
+
+		if bulkRequest.NumberOfActions() >= batchSize || i == numOfDoc-1 {
+			bulkResponse, err := bulkRequest.Do(ctx)
+			if err != nil {
+				panic(err)
+			}
+			if bulkResponse.Errors {
+				fmt.Println("bulk has failed items: ", len(bulkResponse.Failed()))
+			}
+		}
+	}
+}
+
+// backgroundVisibilityLoad issues list queries against readIndex and single
+// document writes against writeIndex until stop is closed.
+func backgroundVisibilityLoad(client *elastic.Client, readIndex, writeIndex string, base int64, days int, stop chan struct{},
+	done *sync.WaitGroup, reads, writes *retentionLatency) {
+	defer done.Done()
+
+	ctx := context.Background()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		low := base + int64(days-1)*dayInMillis
+		matchQuery := elastic.NewMatchQuery("workflow_type_name", retentionWorkflowTypeName)
+		rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lte(low + dayInMillis)
+		boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+
+		reqStartTime := time.Now()
+		_, err := client.Search().Index(readIndex).Query(boolQuery).
+			Sort("close_time", false).
+			From(0).Size(10).
+			Do(ctx)
+		reads.record(time.Since(reqStartTime), err)
+		timelineRetention.record("read", time.Since(reqStartTime), err)
+
+		rid := uuid.New()
+		millis := time.Now().UnixNano() / 1e6
+		body := RetentionWorkflow{
+			WorkflowID:       rid,
+			RunID:            rid,
+			WorkflowTypeName: retentionWorkflowTypeName,
+			Status:           0,
+			StartTime:        millis - 3600,
+			CloseTime:        millis,
+			HistoryLength:    1024,
+			Info:             "some info",
+		}
+		reqStartTime = time.Now()
+		_, err = client.Index().Index(writeIndex).Type("_doc").Id(rid + "_" + rid).BodyJson(body).Do(ctx)
+		writes.record(time.Since(reqStartTime), err)
+		timelineRetention.record("write", time.Since(reqStartTime), err)
+	}
+}
+
+// waitForRetentionQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForRetentionQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
+		if err != nil {
+			return err
+		}
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+// runRetentionPhase runs cleanup while numOfThread goroutines keep reading
+// and writing the given indices, and prints the latency they observed.
+func runRetentionPhase(name string, client *elastic.Client, readIndex, writeIndex string, base int64, days, numOfThread int, cleanup func()) {
+	// the load and the cleanup of the previous phase leave merges behind
+	err := waitForRetentionQuiescence(client, []string{readIndex, writeIndex}, retentionWaitForStatus, retentionWaitForMerges, retentionPreflightTimeout)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	var reads, writes retentionLatency
+	var done sync.WaitGroup
+	stop := make(chan struct{})
+	done.Add(numOfThread)
+	for i := 0; i < numOfThread; i++ {
+		go backgroundVisibilityLoad(client, readIndex, writeIndex, base, days, stop, &done, &reads, &writes)
+	}
+
+	startTime := time.Now()
+	cleanup()
+	elapsedTime := time.Since(startTime)
+
+	close(stop)
+	done.Wait()
+
+	fmt.Println("------ " + name + " ------")
+	fmt.Println("phase time: ", elapsedTime)
+	fmt.Println("background reads: ", reads.count, " errors: ", reads.errors)
+	fmt.Println("avg read time: ", reads.avg(), " max read time: ", reads.max)
+	fmt.Println("background writes: ", writes.count, " errors: ", writes.errors)
+	fmt.Println("avg write time: ", writes.avg(), " max write time: ", writes.max)
+}
+
+func deleteDaySync(ctx context.Context, client *elastic.Client, base int64, day int) {
+	low := base + int64(day)*dayInMillis
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lt(low + dayInMillis)
+
+	startTime := time.Now()
+	res, err := client.DeleteByQuery(retentionDomainID).Query(rangeQuery).
+		ProceedOnVersionConflict().
+		Do(ctx)
+	timelineRetention.record("delete", time.Since(startTime), err)
+	if err != nil {
+		fmt.Println("delete by query failed ", err)
+		return
+	}
+	elapsedTime := time.Since(startTime)
+
+	fmt.Println("sync deleted: ", res.Deleted, " took millis: ", res.Took, " batches: ", res.Batches)
+	fmt.Println("sync version conflicts: ", res.VersionConflicts, " failures: ", len(res.Failures))
+	if elapsedTime > 0 {
+		fmt.Println("sync deleted docs/sec: ", float64(res.Deleted)/elapsedTime.Seconds())
+	}
+}
+
+func deleteDayAsync(ctx context.Context, client *elastic.Client, base int64, day int, pollInterval time.Duration) {
+	low := base + int64(day)*dayInMillis
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lt(low + dayInMillis)
+
+	startTime := time.Now()
+	task, err := client.DeleteByQuery(retentionDomainID).Query(rangeQuery).
+		ProceedOnVersionConflict().
+		DoAsync(ctx)
+	if err != nil {
+		timelineRetention.record("delete", time.Since(startTime), err)
+		fmt.Println("delete by query failed ", err)
+		return
+	}
+	fmt.Println("started task ", task.TaskId)
+
+	var deleted int64
+	polls := 0
+	for {
+		polls++
+		res, err := client.TasksGetTask().TaskId(task.TaskId).Do(ctx)
+		if err != nil {
+			timelineRetention.record("delete", time.Since(startTime), err)
+			fmt.Println("get task failed ", err)
+			return
+		}
+		if res.Task != nil {
+			if status, ok := res.Task.Status.(map[string]interface{}); ok {
+				if d, ok := status["deleted"].(float64); ok {
+					deleted = int64(d)
+				}
+			}
+		}
+		if res.Completed {
+			if res.Task != nil {
+				fmt.Println("async task running time: ", time.Duration(res.Task.RunningTimeInNanos))
+			}
+			break
+		}
+		time.Sleep(pollInterval)
+	}
+	elapsedTime := time.Since(startTime)
+	timelineRetention.record("delete", elapsedTime, nil)
+
+	fmt.Println("async deleted: ", deleted, " polls: ", polls)
+	if elapsedTime > 0 {
+		fmt.Println("async deleted docs/sec: ", float64(deleted)/elapsedTime.Seconds())
+	}
+}
+
+func dropDay(ctx context.Context, client *elastic.Client, day int) {
+	index := dailyIndexName(day)
+	count, err := client.Count(index).Do(ctx)
+	if err != nil {
+		fmt.Println("count failed ", err)
+	}
+
+	startTime := time.Now()
+	_, err = client.DeleteIndex(index).Do(ctx)
+	elapsedTime := time.Since(startTime)
+	timelineRetention.record("delete", elapsedTime, err)
+	if err != nil {
+		fmt.Println("drop index failed ", err)
+		return
+	}
+
+	fmt.Println("dropped index ", index, " docs: ", count, " took: ", elapsedTime)
+	if elapsedTime > 0 {
+		fmt.Println("drop deleted docs/sec: ", float64(count)/elapsedTime.Seconds())
+	}
+}
+
+func main() {
+	var numOfDays int
+	fmt.Println("Number of days to load (at least 3): ")
+	fmt.Scanln(&numOfDays)
+
+	var numOfDocPerDay int
+	fmt.Println("Number of docs per day: ")
+	fmt.Scanln(&numOfDocPerDay)
+
+	var bulkSize int
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var numOfThread int
+	fmt.Println("Number of background go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	if numOfDays < 3 {
+		numOfDays = 3
+	}
+
+	if numOfDocPerDay <= 0 {
+		numOfDocPerDay = 100000
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 5000
+	}
+
+	fmt.Println("Wait for health before every phase (green/yellow): ")
+	fmt.Scanln(&retentionWaitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before every phase (y/n): ")
+	fmt.Scanln(&waitForMerges)
+	retentionWaitForMerges = waitForMerges == "y"
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if retentionWaitForStatus != "green" {
+		retentionWaitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+	retentionPreflightTimeout = time.Duration(preflightTimeout) * time.Second
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	// all days are in the past so that background writes never get deleted
+	millis := time.Now().UnixNano() / 1e6
+	base := millis - int64(numOfDays)*dayInMillis
+
+	recreateIndex(ctx, client, retentionDomainID)
+	for d := 0; d < numOfDays; d++ {
+		recreateIndex(ctx, client, dailyIndexName(d))
+	}
+
+	fmt.Println("start loading data")
+	startTime := time.Now()
+	for d := 0; d < numOfDays; d++ {
+		loadRetentionDay(ctx, client, retentionDomainID, base, d, numOfDocPerDay, bulkSize)
+		loadRetentionDay(ctx, client, dailyIndexName(d), base, d, numOfDocPerDay, bulkSize)
+		fmt.Println("loaded day ", d)
+	}
+	if _, err := client.Refresh().Do(ctx); err != nil {
+		panic(err)
+	}
+	fmt.Println("load time: ", time.Since(startTime))
+
+	// with daily indices reads go across all days and writes go to the newest
+	dailyIndices := retentionDomainID + "-day-*"
+	newestDay := dailyIndexName(numOfDays - 1)
+
+	// the timeline covers the phases, not the initial load
+	runStartTime := time.Now()
+	timelineRetention.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	runRetentionPhase("Baseline (no cleanup)", client, retentionDomainID, retentionDomainID, base, numOfDays, numOfThread, func() {
+		time.Sleep(10 * time.Second)
+	})
+	runRetentionPhase("Delete By Query (sync)", client, retentionDomainID, retentionDomainID, base, numOfDays, numOfThread, func() {
+		deleteDaySync(ctx, client, base, 0)
+	})
+	runRetentionPhase("Delete By Query (async task)", client, retentionDomainID, retentionDomainID, base, numOfDays, numOfThread, func() {
+		deleteDayAsync(ctx, client, base, 1, time.Second)
+	})
+	runRetentionPhase("Baseline Daily Indices (no cleanup)", client, dailyIndices, newestDay, base, numOfDays, numOfThread, func() {
+		time.Sleep(10 * time.Second)
+	})
+	runRetentionPhase("Drop Daily Index", client, dailyIndices, newestDay, base, numOfDays, numOfThread, func() {
+		dropDay(ctx, client, 0)
+	})
+
+	writeRetentionReport(retentionRunReport{
+		Workload:  "delete_visibility",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"days":              numOfDays,
+			"docs_per_day":      numOfDocPerDay,
+			"bulk_size":         bulkSize,
+			"go_routines":       numOfThread,
+			"wait_for_status":   retentionWaitForStatus,
+			"wait_for_merges":   retentionWaitForMerges,
+			"timeline_window_s": timelineWindow,
+		},
+		Timeline: timelineRetention.points(),
+	})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+const insight_delivery_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	}
+}`
+
+const deliveryDomainID = "delivery-843c-4055-8baa-de52d697335d"
+
+// deliveryEvent is one state change in the update_insight_bulk2.go model,
+// doc id is entity + "_" + state and version grows with every event.
+type deliveryEvent struct {
+	id      string
+	state   string
+	value   string
+	version int64
+}
+
+// delayedDeliveryEvent is held back until remaining more events are delivered.
+type delayedDeliveryEvent struct {
+	event     deliveryEvent
+	remaining int
+}
+
+// deliverySimulator turns the in order event stream into an at least once,
+// reordered stream like a Kafka consumer would see after rebalances and retries.
+type deliverySimulator struct {
+	r          *rand.Rand
+	pDuplicate float64
+	pDelay     float64
+	pReorder   float64
+	maxDelay   int
+
+	delayed []delayedDeliveryEvent
+
+	duplicated int
+	delayedCnt int
+	reordered  int
+}
+
+// deliver returns the events to hand to the writer after e was produced.
+func (s *deliverySimulator) deliver(e deliveryEvent) []deliveryEvent {
+	var out []deliveryEvent
+
+	var pending []delayedDeliveryEvent
+	for _, d := range s.delayed {
+		d.remaining--
+		if d.remaining <= 0 {
+			out = append(out, d.event)
+		} else {
+			pending = append(pending, d)
+		}
+	}
+	s.delayed = pending
+
+	if s.r.Float64() < s.pDuplicate {
+		s.duplicated++
+		s.delayed = append(s.delayed, delayedDeliveryEvent{event: e, remaining: 1 + s.r.Intn(s.maxDelay)})
+	}
+
+	if s.r.Float64() < s.pDelay {
+		s.delayedCnt++
+		s.delayed = append(s.delayed, delayedDeliveryEvent{event: e, remaining: 1 + s.r.Intn(s.maxDelay)})
+	} else {
+		out = append(out, e)
+	}
+
+	if len(out) > 1 && s.r.Float64() < s.pReorder {
+		s.reordered++
+		i := s.r.Intn(len(out) - 1)
+		out[i], out[i+1] = out[i+1], out[i]
+	}
+	return out
+}
+
+// flush delivers everything still held back.
+func (s *deliverySimulator) flush() []deliveryEvent {
+	var out []deliveryEvent
+	for _, d := range s.delayed {
+		out = append(out, d.event)
+	}
+	s.delayed = nil
+	s.r.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+type deliveryWriterStats struct {
+	lock     sync.Mutex
+	written  int64
+	stale    int64
+	failed   int64
+	bulkFail int64
+}
+
+func writeDeliveredEvents(client *elastic.Client, batches chan []deliveryEvent, done *sync.WaitGroup, stats *deliveryWriterStats) {
+	defer done.Done()
+
+	for batch := range batches {
+		bulkRequest := client.Bulk()
+		for _, e := range batch {
+			doc := map[string]interface{}{"state": e.state, "value": e.value, "update_time": e.version / 1000}
+			req := elastic.NewBulkIndexRequest().Index(deliveryDomainID).Type("_doc").Id(e.id).Doc(doc).
+				VersionType("external").Version(e.version)
+			bulkRequest.Add(req)
+		}
+
+		bulkResponse, err := bulkRequest.Do(context.Background())
+		stats.lock.Lock()
+		if err != nil {
+			fmt.Println("bulk failed", err)
+			stats.bulkFail++
+			stats.failed += int64(len(batch))
+			stats.lock.Unlock()
+			continue
+		}
+		stale := int64(0)
+		failed := int64(0)
+		for _, item := range bulkResponse.Failed() {
+			// an older version than the one indexed is rejected, which is the
+			// expected outcome for late and duplicate events
+			if item.Status == 409 {
+				stale++
+			} else {
+				failed++
+			}
+		}
+		stats.stale += stale
+		stats.failed += failed
+		stats.written += int64(len(batch)) - stale - failed
+		stats.lock.Unlock()
+	}
+}
+
+// verifyDeliveredState fetches every expected doc and compares it with the
+// newest event generated for it.
+func verifyDeliveredState(ctx context.Context, client *elastic.Client, expected map[string]deliveryEvent) (int, int, int) {
+	var ids []string
+	for id := range expected {
+		ids = append(ids, id)
+	}
+
+	missing, staleVersion, wrongValue := 0, 0, 0
+	for start := 0; start < len(ids); start += 1000 {
+		end := start + 1000
+		if end > len(ids) {
+			end = len(ids)
+		}
+		mget := client.Mget()
+		for _, id := range ids[start:end] {
+			mget = mget.Add(elastic.NewMultiGetItem().Index(deliveryDomainID).Type("_doc").Id(id))
+		}
+		res, err := mget.Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, doc := range res.Docs {
+			e := expected[doc.Id]
+			if !doc.Found || doc.Source == nil {
+				missing++
+				continue
+			}
+			if doc.Version == nil || *doc.Version != e.version {
+				staleVersion++
+				if staleVersion <= 10 {
+					fmt.Println("stale version ", doc.Id, " expected: ", e.version)
+				}
+			}
+			var source map[string]interface{}
+			if err := json.Unmarshal(*doc.Source, &source); err != nil {
+				panic(err)
+			}
+			if source["value"] != e.value {
+				wrongValue++
+			}
+		}
+	}
+	return missing, staleVersion, wrongValue
+}
+
+func main() {
+	var numOfEvents int
+	fmt.Println("Number of events: ")
+	fmt.Scanln(&numOfEvents)
+
+	var numOfEntities int
+	fmt.Println("Number of entities: ")
+	fmt.Scanln(&numOfEntities)
+
+	var numOfThread int
+	fmt.Println("Number of writer go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	var bulkSize int
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var pDuplicate, pDelay, pReorder float64
+	fmt.Println("Probability of duplicate delivery (e.g. 0.05): ")
+	fmt.Scanln(&pDuplicate)
+	fmt.Println("Probability of delayed delivery (e.g. 0.05): ")
+	fmt.Scanln(&pDelay)
+	fmt.Println("Probability of reordering within a delivery (e.g. 0.05): ")
+	fmt.Scanln(&pReorder)
+
+	var maxDelay int
+	fmt.Println("Max delay in events: ")
+	fmt.Scanln(&maxDelay)
+
+	if numOfEvents <= 0 {
+		numOfEvents = 100000
+	}
+
+	if numOfEntities <= 0 {
+		numOfEntities = 1000
+	}
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	if maxDelay <= 0 {
+		maxDelay = 1000
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(deliveryDomainID).Do(ctx)
+	if exists {
+		fmt.Println("delete index ", deliveryDomainID)
+		if _, err := client.DeleteIndex(deliveryDomainID).Do(ctx); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Println("create index ", deliveryDomainID)
+	createIndex, err := client.CreateIndex(deliveryDomainID).BodyString(insight_delivery_setting).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !createIndex.Acknowledged {
+		// Not acknowledged
+	}
+
+	baseDocID := uuid.New() + "_"
+	var stateKeys []string
+	for i := 0; i < 50; i++ {
+		stateKeys = append(stateKeys, "state_key_"+strconv.Itoa(i))
+	}
+
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	sim := &deliverySimulator{
+		r:          r,
+		pDuplicate: pDuplicate,
+		pDelay:     pDelay,
+		pReorder:   pReorder,
+		maxDelay:   maxDelay,
+	}
+
+	var stats deliveryWriterStats
+	var done sync.WaitGroup
+	batches := make(chan []deliveryEvent, numOfThread)
+	done.Add(numOfThread)
+	for i := 0; i < numOfThread; i++ {
+		go writeDeliveredEvents(client, batches, &done, &stats)
+	}
+
+	// version is in micros so every generated event has a newer version
+	expected := make(map[string]deliveryEvent)
+	version := time.Now().UnixNano() / 1e3
+	delivered := 0
+	var batch []deliveryEvent
+	startTime := time.Now()
+	for i := 0; i < numOfEvents; i++ {
+		version++
+		state := stateKeys[r.Intn(len(stateKeys))]
+		e := deliveryEvent{
+			id:      baseDocID + strconv.Itoa(r.Intn(numOfEntities)) + "_" + state,
+			state:   state,
+			value:   "state_value_" + strconv.Itoa(r.Intn(100)),
+			version: version,
+		}
+		expected[e.id] = e
+
+		batch = append(batch, sim.deliver(e)...)
+		if len(batch) >= bulkSize {
+			delivered += len(batch)
+			batches <- batch
+			batch = nil
+		}
+	}
+	batch = append(batch, sim.flush()...)
+	delivered += len(batch)
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	done.Wait()
+	elapsedTime := time.Since(startTime)
+
+	if _, err := client.Refresh(deliveryDomainID).Do(ctx); err != nil {
+		panic(err)
+	}
+	missing, staleVersion, wrongValue := verifyDeliveredState(ctx, client, expected)
+
+	fmt.Println("------ Delivery Simulation ------")
+	fmt.Println("time: ", elapsedTime)
+	fmt.Println("generated events: ", numOfEvents, " delivered events: ", delivered)
+	fmt.Println("duplicated: ", sim.duplicated, " delayed: ", sim.delayedCnt, " reordered: ", sim.reordered)
+	fmt.Println("written: ", stats.written, " rejected as stale: ", stats.stale, " failed: ", stats.failed, " failed bulks: ", stats.bulkFail)
+	fmt.Println("------ End State ------")
+	fmt.Println("expected docs: ", len(expected))
+	fmt.Println("missing: ", missing)
+	fmt.Println("not at newest version: ", staleVersion)
+	fmt.Println("wrong value: ", wrongValue)
+	if missing == 0 && staleVersion == 0 && wrongValue == 0 {
+		fmt.Println("end state matches newest version of every document")
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+type MatrixWorkflow struct {
+	WorkflowID       string `json:"workflow_id"`
+	RunID            string `json:"run_id"`
+	WorkflowTypeName string `json:"workflow_type_name"`
+	Status           int    `json:"status"`
+	StartTime        int64  `json:"start_time"`
+	CloseTime        int64  `json:"close_time"`
+	HistoryLength    int    `json:"history_length"`
+	Info             string `json:"info,omitempty"`
+}
+
+const matrixDomainPrefix = "matrix00-69f9-4495-a1b2-6ea71b5fa459-"
+const matrixWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+// preflight of every case, see waitForMatrixQuiescence
+var matrixWaitForStatus string
+var matrixWaitForMerges bool
+var matrixPreflightTimeout time.Duration
+
+// indexSettingsCase is one cell of the experiment matrix.
+type indexSettingsCase struct {
+	Shards             int
+	Replicas           int
+	RefreshInterval    string
+	TranslogDurability string
+	SortOnCloseTime    bool
+	Codec              string
+}
+
+func (c indexSettingsCase) body() string {
+	settings := map[string]interface{}{
+		"number_of_shards":    c.Shards,
+		"number_of_replicas":  c.Replicas,
+		"refresh_interval":    c.RefreshInterval,
+		"translog.durability": c.TranslogDurability,
+		"codec":               c.Codec,
+	}
+	if c.SortOnCloseTime {
+		settings["sort.field"] = "close_time"
+		settings["sort.order"] = "desc"
+	}
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{"index": settings},
+		"mappings": map[string]interface{}{
+			"_doc": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"close_time": map[string]interface{}{"type": "long"},
+				},
+			},
+		},
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// matrixResult is the outcome of running the scenario against one case.
+type matrixResult struct {
+	docsPerSec  float64
+	avgBulkTime time.Duration
+	failedDocs  int
+	avgReadTime time.Duration
+	maxReadTime time.Duration
+	storeBytes  int64
+}
+
+func expandSettingsMatrix(shards, replicas []int, refreshIntervals, durabilities []string,
+	sorts []bool, codecs []string) []indexSettingsCase {
+	var cases []indexSettingsCase
+	for _, s := range shards {
+		for _, r := range replicas {
+			for _, ri := range refreshIntervals {
+				for _, d := range durabilities {
+					for _, so := range sorts {
+						for _, c := range codecs {
+							cases = append(cases, indexSettingsCase{
+								Shards:             s,
+								Replicas:           r,
+								RefreshInterval:    ri,
+								TranslogDurability: d,
+								SortOnCloseTime:    so,
+								Codec:              c,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	return cases
+}
+
+func matrixBulkLoad(client *elastic.Client, index string, done *sync.WaitGroup, times, batchSize int,
+	lock *sync.Mutex, reqUsed *time.Duration, failedDocs *int) {
+	defer done.Done()
+
+	timeUsed := time.Duration(0)
+	errors := 0
+	for t := 1; t <= times; t++ {
+		bulkRequest := client.Bulk()
+		for i := 0; i < batchSize; i++ {
+			millis := time.Now().UnixNano() / 1e6
+			rid := uuid.New()
+			body := MatrixWorkflow{
+				WorkflowID:       rid,
+				RunID:            rid,
+				WorkflowTypeName: matrixWorkflowTypeName,
+				Status:           0,
+				StartTime:        millis - 3600,
+				CloseTime:        millis,
+				HistoryLength:    1024,
+				Info:             "some info",
+			}
+			req := elastic.NewBulkIndexRequest().Index(index).Type("_doc").Id(rid + "_" + rid).Doc(body)
+			bulkRequest.Add(req)
+		}
+
+		reqStartTime := time.Now()
+		bulkResponse, err := bulkRequest.Do(context.Background())
+		timeUsed += time.Since(reqStartTime)
+		if err != nil {
+			fmt.Println("bulk failed", err)
+			errors += batchSize
+			continue
+		}
+		errors += len(bulkResponse.Failed())
+	}
+
+	lock.Lock()
+	*reqUsed += timeUsed
+	*failedDocs += errors
+	lock.Unlock()
+}
+
+// waitForMatrixQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForMatrixQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
+		if err != nil {
+			return err
+		}
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func runMatrixCase(ctx context.Context, client *elastic.Client, index string, c indexSettingsCase,
+	numOfThread, numOfRequestPerThread, bulkSize, numOfReads int) matrixResult {
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		if _, err := client.DeleteIndex(index).Do(ctx); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Println("create index ", index, " ", c.body())
+	createIndex, err := client.CreateIndex(index).BodyString(c.body()).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !createIndex.Acknowledged {
+		// Not acknowledged
+	}
+
+	// every case starts on a new index, measure it once its shards are
+	// allocated and not while they are still initializing
+	err = waitForMatrixQuiescence(client, []string{index}, matrixWaitForStatus, matrixWaitForMerges, matrixPreflightTimeout)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	var result matrixResult
+	var done sync.WaitGroup
+	var lock sync.Mutex
+	var reqUsed time.Duration
+	done.Add(numOfThread)
+	startTime := time.Now()
+	for i := 0; i < numOfThread; i++ {
+		go matrixBulkLoad(client, index, &done, numOfRequestPerThread, bulkSize, &lock, &reqUsed, &result.failedDocs)
+	}
+	done.Wait()
+	elapsedTime := time.Since(startTime)
+
+	numOfBulk := numOfThread * numOfRequestPerThread
+	// only the docs that were written count towards docs/sec
+	result.docsPerSec = float64(numOfBulk*bulkSize-result.failedDocs) / elapsedTime.Seconds()
+	result.avgBulkTime = time.Duration(int64(reqUsed) / int64(numOfBulk))
+
+	if _, err := client.Refresh(index).Do(ctx); err != nil {
+		panic(err)
+	}
+
+	var readUsed time.Duration
+	reads := 0
+	for i := 0; i < numOfReads; i++ {
+		millis := time.Now().UnixNano() / 1e6
+		matchQuery := elastic.NewMatchQuery("workflow_type_name", matrixWorkflowTypeName)
+		rangeQuery := elastic.NewRangeQuery("close_time").Gte(millis - 3600000).Lte(millis)
+		boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+
+		reqStartTime := time.Now()
+		_, err := client.Search().Index(index).Query(boolQuery).
+			Sort("close_time", false).
+			From(0).Size(10).
+			Do(ctx)
+		if err != nil {
+			fmt.Println("search failed ", err)
+			continue
+		}
+		t := time.Since(reqStartTime)
+		reads++
+		readUsed += t
+		if t > result.maxReadTime {
+			result.maxReadTime = t
+		}
+	}
+	// failed reads have no latency to average
+	if reads > 0 {
+		result.avgReadTime = time.Duration(int64(readUsed) / int64(reads))
+	}
+
+	stats, err := client.IndexStats(index).Do(ctx)
+	if err != nil {
+		fmt.Println("index stats failed ", err)
+	} else if s, ok := stats.Indices[index]; ok && s.Total != nil && s.Total.Store != nil {
+		result.storeBytes = s.Total.Store.SizeInBytes
+	}
+	return result
+}
+
+func readMatrixLine(reader *bufio.Reader, prompt, defaultValue string) []string {
+	fmt.Println(prompt + " (comma separated, default " + defaultValue + "): ")
+	line, _ := reader.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(line, ",") {
+		values = append(values, strings.TrimSpace(v))
+	}
+	return values
+}
+
+// readMatrixInt reads a single number from the same reader as the matrix
+// lines, since mixing it with fmt.Scanln would lose buffered input.
+func readMatrixInt(reader *bufio.Reader, prompt string) int {
+	fmt.Println(prompt)
+	line, _ := reader.ReadString('\n')
+	i, _ := strconv.Atoi(strings.TrimSpace(line))
+	return i
+}
+
+// readMatrixString reads a single word from the same reader as the matrix
+// lines.
+func readMatrixString(reader *bufio.Reader, prompt string) string {
+	fmt.Println(prompt)
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+func atoiMatrix(values []string) []int {
+	var ints []int
+	for _, v := range values {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			panic(err)
+		}
+		ints = append(ints, i)
+	}
+	return ints
+}
+
+func main() {
+	reader := bufio.NewReader(os.Stdin)
+
+	shards := atoiMatrix(readMatrixLine(reader, "Number of shards", "5"))
+	replicas := atoiMatrix(readMatrixLine(reader, "Number of replicas", "1"))
+	refreshIntervals := readMatrixLine(reader, "Refresh interval", "1s")
+	durabilities := readMatrixLine(reader, "Translog durability", "request")
+	var sorts []bool
+	for _, v := range readMatrixLine(reader, "Index sort on close_time", "false") {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			panic(err)
+		}
+		sorts = append(sorts, b)
+	}
+	codecs := readMatrixLine(reader, "Codec", "default")
+
+	numOfThread := readMatrixInt(reader, "Number of go routines: ")
+
+	numOfRequestPerThread := readMatrixInt(reader, "Number of request per go routines: ")
+
+	bulkSize := readMatrixInt(reader, "Bulk size: ")
+
+	numOfReads := readMatrixInt(reader, "Number of read requests: ")
+
+	matrixWaitForStatus = readMatrixString(reader, "Wait for health before each case (green/yellow): ")
+
+	matrixWaitForMerges = readMatrixString(reader, "Wait for no active merges before each case (y/n): ") == "y"
+
+	preflightTimeout := readMatrixInt(reader, "Preflight timeout seconds: ")
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	if numOfReads <= 0 {
+		numOfReads = 100
+	}
+
+	if matrixWaitForStatus != "green" {
+		matrixWaitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+	matrixPreflightTimeout = time.Duration(preflightTimeout) * time.Second
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	cases := expandSettingsMatrix(shards, replicas, refreshIntervals, durabilities, sorts, codecs)
+	fmt.Println("number of cases: ", len(cases))
+
+	var results []matrixResult
+	for i, c := range cases {
+		index := matrixDomainPrefix + strconv.Itoa(i)
+		results = append(results, runMatrixCase(ctx, client, index, c, numOfThread, numOfRequestPerThread, bulkSize, numOfReads))
+		if _, err := client.DeleteIndex(index).Do(ctx); err != nil {
+			fmt.Println("delete index failed ", err)
+		}
+	}
+
+	fmt.Println("------ Index Settings Matrix ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "shards\treplicas\trefresh\ttranslog\tsort\tcodec\tdocs/sec\tavg bulk\tfailed docs\tavg read\tmax read\tstore bytes\t")
+	for i, c := range cases {
+		r := results[i]
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%t\t%s\t%.0f\t%v\t%d\t%v\t%v\t%d\t\n",
+			c.Shards, c.Replicas, c.RefreshInterval, c.TranslogDurability, c.SortOnCloseTime, c.Codec,
+			r.docsPerSec, r.avgBulkTime, r.failedDocs, r.avgReadTime, r.maxReadTime, r.storeBytes)
+	}
+	w.Flush()
+}
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"github.com/olivere/elastic"
 	"github.com/pborman/uuid"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 )
 
+const insightDomainID = "100cd4ec-843c-4055-8baa-de52d697335d"
+
 const insight_index_setting = `
 {
 	"settings":{
@@ -20,10 +25,395 @@ const insight_index_setting = `
 	}
 }`
 
+// insightProgress sums up the upserts of all go routines into one status
+// line.
+type insightProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowLatencies []time.Duration
+}
+
+var progressInsight = &insightProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *insightProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *insightProgress) record(latency time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	if err != nil {
+		p.errors++
+	}
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *insightProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// insightTimelinePoint is what one operation type did within one window of the
+// run, like TimelinePoint in insert_visibility_bulk.go.
+type insightTimelinePoint struct {
+	OffsetSec float64 `json:"offset_sec"`
+	Operation string  `json:"operation"`
+	Ops       int64   `json:"ops"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	Errors    int64   `json:"errors"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+type insightTimelineBucket struct {
+	latencies []time.Duration
+	errors    int64
+}
+
+// insightTimeline buckets every request by operation type and by the window it
+// finished in.
+type insightTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*insightTimelineBucket
+}
+
+var timelineInsight = &insightTimeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *insightTimeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*insightTimelineBucket)
+}
+
+func (t *insightTimeline) record(op string, latency time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*insightTimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &insightTimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	if err != nil {
+		b.errors++
+	}
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *insightTimeline) points() []insightTimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []insightTimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := insightTimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = insightPercentileMs(b.latencies, 50)
+				p.P90Ms = insightPercentileMs(b.latencies, 90)
+				p.P99Ms = insightPercentileMs(b.latencies, 99)
+				p.MaxMs = insightPercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func insightPercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// insightTelemetrySample is one sample of the cluster during the run, with the
+// fields of TelemetrySample in insert_visibility_bulk.go.
+type insightTelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// insightRunReport is written as JSON at the end of a run in the layout of the
+// insert_visibility_bulk.go run report, so report_html.go can show it.
+type insightRunReport struct {
+	Workload   string                   `json:"workload"`
+	StartTime  time.Time                `json:"start_time"`
+	Parameters map[string]interface{}   `json:"parameters"`
+	Telemetry  []insightTelemetrySample `json:"telemetry"`
+	Timeline   []insightTimelinePoint   `json:"timeline"`
+}
+
+// sampleInsightTelemetry reads node, cluster and index stats every interval
+// until stop is closed. Offsets are relative to startTime and indexing rate is
+// derived from the previous sample.
+func sampleInsightTelemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]insightTelemetrySample) {
+	defer done.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := insightTelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func writeInsightReport(report insightRunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+// waitForInsightQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForInsightQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
+		if err != nil {
+			return err
+		}
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
 func insertInsight(threadID string, done *sync.WaitGroup, times int, duration *time.Duration) {
 	defer done.Done()
 
-	domainID := "100cd4ec-843c-4055-8baa-de52d697335d"
+	domainID := insightDomainID
 	numOfStateKey := 50
 	numOfStateValue := 100
 	var stateKey []string
@@ -40,18 +430,6 @@ func insertInsight(threadID string, done *sync.WaitGroup, times int, duration *t
 	if err != nil {
 		panic(err)
 	}
-	exists, err := client.IndexExists(domainID).Do(ctx)
-	if !exists {
-		fmt.Println("create index ", domainID)
-		createIndex, err := client.CreateIndex(domainID).BodyString(insight_index_setting).Do(ctx)
-		if err != nil {
-			panic(err)
-		}
-		if !createIndex.Acknowledged {
-			// Not acknowledged
-		}
-	}
-
 	fmt.Println("start execute query")
 	startTime := time.Now()
 
@@ -71,20 +449,19 @@ func insertInsight(threadID string, done *sync.WaitGroup, times int, duration *t
 			panic(err)
 		}
 
+		reqStartTime := time.Now()
 		_, err := client.Update().Index(domainID).Type("_doc").Id(id).Doc(req).DocAsUpsert(true).Do(ctx)
+		progressInsight.record(time.Since(reqStartTime), err)
+		timelineInsight.record("upsert", time.Since(reqStartTime), err)
 		if err != nil {
 			fmt.Println(err)
 		}
 		//fmt.Println(upd)
 
 		i += 1
-		if i%2000 == 0 {
-			fmt.Println(threadID, i)
-		}
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 }
 
@@ -97,10 +474,85 @@ func main() {
 	fmt.Println("Number of request per go routines: ")
 	fmt.Scanln(&numOfRequestPerThread)
 
+	var waitForStatus string
+	fmt.Println("Wait for health before start (green/yellow): ")
+	fmt.Scanln(&waitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before start (y/n): ")
+	fmt.Scanln(&waitForMerges)
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
 	if numOfThread <= 0 {
 		numOfThread = 1
 	}
 
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	if waitForStatus != "green" {
+		waitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(insightDomainID).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !exists {
+		fmt.Println("create index ", insightDomainID)
+		createIndex, err := client.CreateIndex(insightDomainID).BodyString(insight_index_setting).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	err = waitForInsightQuiescence(client, []string{insightDomainID}, waitForStatus, waitForMerges == "y", time.Duration(preflightTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	runStartTime := time.Now()
+	timelineInsight.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	var telemetry []insightTelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleInsightTelemetry(client, []string{insightDomainID}, runStartTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	progressInsight.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressInsight.show(stopProgress, &progress)
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -108,5 +560,24 @@ func main() {
 		go insertInsight(strconv.Itoa(i), &done, numOfRequestPerThread, &duration)
 	}
 	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+	close(stopSampling)
+	sampler.Wait()
 	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
+
+	writeInsightReport(insightRunReport{
+		Workload:  "insert_insight",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"go_routines":          numOfThread,
+			"requests_per_routine": numOfRequestPerThread,
+			"wait_for_status":      waitForStatus,
+			"wait_for_merges":      waitForMerges == "y",
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Telemetry: telemetry,
+		Timeline:  timelineInsight.points(),
+	})
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"encoding/json"
@@ -21,6 +23,99 @@ const insight_bulk_setting = `
 	}
 }`
 
+// insightBulkProgress is the status line of the bulk inserts. Docs are the
+// items of a bulk that did not fail.
+type insightBulkProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressInsightBulk = &insightBulkProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *insightBulkProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *insightBulkProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *insightBulkProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
 func insertInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
 	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
 	defer done.Done()
@@ -86,25 +181,25 @@ func insertInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize i
 		reqStartTime := time.Now()
 
 		bulkResponse, err := bulkRequest.Do(context.Background())
+		reqTime := time.Since(reqStartTime)
 		if err != nil {
 			fmt.Println("bulk failed", err)
+			progressInsightBulk.record(reqTime, 0, batchSize)
+		} else {
+			failed := len(bulkResponse.Failed())
+			progressInsightBulk.record(reqTime, batchSize-failed, failed)
 		}
 
-		timeUsed += time.Since(reqStartTime)
+		timeUsed += reqTime
 
 		if bulkRequest.NumberOfActions() != 0 {
 			fmt.Printf("bulk request not done %d\n", bulkRequest.NumberOfActions())
 		}
 
 		bulkUsed += int64(bulkResponse.Took)
-
-		if t%2000 == 0 {
-			fmt.Println(threadID, t)
-		}
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 	*bulkTook += bulkUsed / int64(times)
 	*reqUsed += time.Duration(int64(timeUsed) / int64(times))
@@ -137,6 +232,11 @@ func main() {
 		bulkSize = 20000
 	}
 
+	progressInsightBulk.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressInsightBulk.show(stopProgress, &progress)
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -147,6 +247,8 @@ func main() {
 		go insertInsightBulk(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed)
 	}
 	done.Wait()
+	close(stopProgress)
+	progress.Wait()
 	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
 	fmt.Println("avg time on request: ", time.Duration(int64(durationWithoutPrep)/int64(numOfThread)))
 	fmt.Println("avg bulk took: ", bulkTook/int64(numOfThread))
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/olivere/elastic"
@@ -30,6 +32,98 @@ const index_setting = `
 	}
 }`
 
+// visibilityProgress sums up the index requests of all go routines into one
+// status line.
+type visibilityProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowLatencies []time.Duration
+}
+
+var progressVisibility = &visibilityProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *visibilityProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *visibilityProgress) record(latency time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	if err != nil {
+		p.errors++
+	}
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *visibilityProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
 func insertDoc(threadID string, done *sync.WaitGroup, times int, duration *time.Duration) {
 	defer done.Done()
 
@@ -74,20 +168,18 @@ func insertDoc(threadID string, done *sync.WaitGroup, times int, duration *time.
 			Info:             info,
 		}
 
+		reqStartTime := time.Now()
 		_, err := client.Index().Index(domainID).Type("_doc").Id(id).BodyJson(body).Do(ctx)
+		progressVisibility.record(time.Since(reqStartTime), err)
 		if err != nil {
 			fmt.Println(err)
 		}
 		//fmt.Println(put)
 
 		i += 1
-		if i%2000 == 0 {
-			fmt.Println(threadID, i)
-		}
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 }
 
@@ -105,6 +197,11 @@ func main() {
 		numOfThread = 1
 	}
 
+	progressVisibility.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressVisibility.show(stopProgress, &progress)
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -112,5 +209,7 @@ func main() {
 		go insertDoc(strconv.Itoa(i), &done, numOfRequestPerThread, &duration)
 	}
 	done.Wait()
+	close(stopProgress)
+	progress.Wait()
 	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
 }
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
 	"time"
 
 	"github.com/olivere/elastic"
 	"github.com/pborman/uuid"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -30,29 +38,913 @@ const index_bulk_setting = `
 	}
 }`
 
-func insertDocBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
-	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
+// TelemetrySample is the cluster side of a run at one point in time. Counters
+// such as rejections, GC, merges and refreshes are cumulative since node start.
+type TelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// TimelinePoint is what one operation type did within one window of a run.
+// OffsetSec is the start of the window, relative to the start of the run.
+type TimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	Ops        int64   `json:"ops"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Docs       int64   `json:"docs"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+// HistogramBucket counts requests of an operation type that took at most
+// LeMs and more than the previous bucket. The last bucket has LeMs -1 and
+// holds everything slower than the largest bound.
+type HistogramBucket struct {
+	Operation string  `json:"operation"`
+	LeMs      float64 `json:"le_ms"`
+	Count     int64   `json:"count"`
+}
+
+// ErrorCount is how often an operation type failed for one reason.
+type ErrorCount struct {
+	Operation string `json:"operation"`
+	Reason    string `json:"reason"`
+	Count     int64  `json:"count"`
+}
+
+// RunReport is written as JSON at the end of a run.
+type RunReport struct {
+	Workload    string                 `json:"workload"`
+	StartTime   time.Time              `json:"start_time"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Summary     map[string]float64     `json:"summary"`
+	Telemetry   []TelemetrySample      `json:"telemetry"`
+	Timeline    []TimelinePoint        `json:"timeline"`
+	Histogram   []HistogramBucket      `json:"histogram"`
+	Errors      []ErrorCount           `json:"errors"`
+	Trials      []map[string]float64   `json:"trials,omitempty"`
+	TrialStats  map[string]TrialStat   `json:"trial_stats,omitempty"`
+	SLOFailures []string               `json:"slo_failures,omitempty"`
+}
+
+// sloAssertion is one pass/fail criterion on a summary metric, e.g.
+// bulk_p99_ms<500, error_rate_pct<0.1 or docs_per_sec>5000.
+type sloAssertion struct {
+	metric   string
+	lessThan bool
+	limit    float64
+}
+
+func (a sloAssertion) String() string {
+	if a.lessThan {
+		return fmt.Sprintf("%s<%g", a.metric, a.limit)
+	}
+	return fmt.Sprintf("%s>%g", a.metric, a.limit)
+}
+
+// parseSLOs parses a comma separated list of metric<limit or metric>limit.
+func parseSLOs(spec string) ([]sloAssertion, error) {
+	var slos []sloAssertion
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.IndexAny(part, "<>")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid slo %q, want metric<limit or metric>limit", part)
+		}
+		limit, err := strconv.ParseFloat(part[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit in slo %q: %v", part, err)
+		}
+		slos = append(slos, sloAssertion{metric: part[:i], lessThan: part[i] == '<', limit: limit})
+	}
+	return slos, nil
+}
+
+// checkSLOs returns a failure for every assertion summary breaks. A metric
+// missing from summary fails unless skipMissing is set.
+func checkSLOs(slos []sloAssertion, summary map[string]float64, skipMissing bool) []string {
+	var failures []string
+	for _, slo := range slos {
+		value, ok := summary[slo.metric]
+		if !ok {
+			if !skipMissing {
+				failures = append(failures, fmt.Sprintf("%s: metric not reported", slo))
+			}
+			continue
+		}
+		if (slo.lessThan && value >= slo.limit) || (!slo.lessThan && value <= slo.limit) {
+			failures = append(failures, fmt.Sprintf("%s: was %.2f", slo, value))
+		}
+	}
+	return failures
+}
+
+// TrialStat is a summary metric over repeated trials, with the 95%
+// confidence interval of its mean.
+type TrialStat struct {
+	Mean     float64 `json:"mean"`
+	Stddev   float64 `json:"stddev"`
+	CI95Low  float64 `json:"ci95_low"`
+	CI95High float64 `json:"ci95_high"`
+}
+
+const bulkWorkload = "insert_visibility_bulk"
+
+// promLabels are the labels every client side metric has.
+type promLabels struct {
+	workload  string
+	operation string
+}
+
+type promErrorLabels struct {
+	promLabels
+	errorType string
+}
+
+type promHistogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// promMetrics holds the client side metrics served in the Prometheus text
+// format on /metrics. Histogram buckets are latencyBucketsMs.
+type promMetrics struct {
+	lock      sync.Mutex
+	requests  map[promLabels]int64
+	documents map[promLabels]int64
+	bytes     map[promLabels]int64
+	retries   map[promLabels]int64
+	inFlight  map[promLabels]int64
+	errors    map[promErrorLabels]int64
+	durations map[promLabels]*promHistogram
+}
+
+var stressMetrics = &promMetrics{
+	requests:  make(map[promLabels]int64),
+	documents: make(map[promLabels]int64),
+	bytes:     make(map[promLabels]int64),
+	retries:   make(map[promLabels]int64),
+	inFlight:  make(map[promLabels]int64),
+	errors:    make(map[promErrorLabels]int64),
+	durations: make(map[promLabels]*promHistogram),
+}
+
+// maxClientRetries is how often the client retries a failed request.
+var maxClientRetries int
+
+func (m *promMetrics) start(l promLabels, bytes int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inFlight[l]++
+	m.bytes[l] += bytes
+}
+
+func (m *promMetrics) finish(l promLabels, latency time.Duration, docs int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inFlight[l]--
+	m.requests[l]++
+	m.documents[l] += int64(docs)
+	h, ok := m.durations[l]
+	if !ok {
+		h = &promHistogram{counts: make([]int64, len(latencyBucketsMs)+1)}
+		m.durations[l] = h
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	h.counts[sort.SearchFloat64s(latencyBucketsMs, ms)]++
+	h.count++
+	h.sum += latency.Seconds()
+}
+
+func (m *promMetrics) addErrors(l promLabels, errorType string, n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.errors[promErrorLabels{l, errorType}] += int64(n)
+}
+
+func (m *promMetrics) addRetry(l promLabels) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retries[l]++
+}
+
+func promEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func (l promLabels) String() string {
+	return fmt.Sprintf(`workload="%s",operation="%s"`, promEscape(l.workload), promEscape(l.operation))
+}
+
+func writePromCounter(w http.ResponseWriter, name, help, kind string, values map[promLabels]int64) {
+	var labels []promLabels
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].String() < labels[j].String() })
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, l, values[l])
+	}
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (m *promMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePromCounter(w, "stress_requests_total", "Requests sent to Elasticsearch.", "counter", m.requests)
+	writePromCounter(w, "stress_documents_total", "Documents written successfully.", "counter", m.documents)
+	writePromCounter(w, "stress_request_bytes_total", "Estimated request body bytes sent.", "counter", m.bytes)
+	writePromCounter(w, "stress_retries_total", "Requests retried by the client.", "counter", m.retries)
+	writePromCounter(w, "stress_in_flight_requests", "Requests waiting for a response.", "gauge", m.inFlight)
+
+	var errorLabels []promErrorLabels
+	for l := range m.errors {
+		errorLabels = append(errorLabels, l)
+	}
+	sort.Slice(errorLabels, func(i, j int) bool {
+		return errorLabels[i].String()+errorLabels[i].errorType < errorLabels[j].String()+errorLabels[j].errorType
+	})
+	fmt.Fprintf(w, "# HELP stress_errors_total Failed documents or requests by error type.\n# TYPE stress_errors_total counter\n")
+	for _, l := range errorLabels {
+		fmt.Fprintf(w, "stress_errors_total{%s,type=\"%s\"} %d\n", l.promLabels, promEscape(l.errorType), m.errors[l])
+	}
+
+	var labels []promLabels
+	for l := range m.durations {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].String() < labels[j].String() })
+	fmt.Fprintf(w, "# HELP stress_request_duration_seconds Request latency seen by the client.\n# TYPE stress_request_duration_seconds histogram\n")
+	for _, l := range labels {
+		h := m.durations[l]
+		cumulative := int64(0)
+		for i, bound := range latencyBucketsMs {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "stress_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", l, bound/1000, cumulative)
+		}
+		fmt.Fprintf(w, "stress_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(w, "stress_request_duration_seconds_sum{%s} %g\n", l, h.sum)
+		fmt.Fprintf(w, "stress_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+}
+
+// serveMetrics serves /metrics on addr until the process exits.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", stressMetrics)
+	fmt.Println("serving metrics on ", addr, "/metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Println("metrics endpoint failed", err)
+	}
+}
+
+// countingRetrier retries failed requests with exponential backoff and
+// counts every retry, labelled by the operation taken from the URL.
+type countingRetrier struct {
+	backoff elastic.Backoff
+}
+
+func (r *countingRetrier) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
+	if retry > maxClientRetries {
+		return 0, false, nil
+	}
+	wait, ok := r.backoff.Next(retry)
+	if ok {
+		operation := "other"
+		if req != nil && strings.HasSuffix(req.URL.Path, "_bulk") {
+			operation = "bulk"
+		}
+		stressMetrics.addRetry(promLabels{bulkWorkload, operation})
+	}
+	return wait, ok, nil
+}
+
+func newStressClient() (*elastic.Client, error) {
+	if maxClientRetries <= 0 {
+		return elastic.NewClient()
+	}
+	retrier := &countingRetrier{backoff: elastic.NewExponentialBackoff(100*time.Millisecond, 10*time.Second)}
+	return elastic.NewClient(elastic.SetRetrier(retrier))
+}
+
+// bulkRunConfig is what one trial of the bulk insert needs.
+type bulkRunConfig struct {
+	numOfThread           int
+	numOfRequestPerThread int
+	bulkSize              int
+	telemetryInterval     int
+	timelineWindow        int
+	waitForStatus         string
+	waitForMerges         bool
+	preflightTimeout      int
+	recreateIndex         bool
+	numOfTrials           int
+	sloSpec               string
+	slos                  []sloAssertion
+	continuousSLOs        bool
+}
+
+// studentT95 is the two sided 95% t value for 1 to 30 degrees of freedom,
+// above that the normal value is close enough.
+var studentT95 = []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+// latencyBucketsMs are the upper bounds of the latency histogram.
+var latencyBucketsMs = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+type timelineBucket struct {
+	latencies []time.Duration
+	docs      int64
+	errors    int64
+}
+
+// opTimeline buckets every request by operation type and by the window it
+// finished in, so stalls show up instead of being averaged away.
+type opTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*timelineBucket
+	reasons   map[string]map[string]int64
+}
+
+func newOpTimeline(startTime time.Time, window time.Duration) *opTimeline {
+	return &opTimeline{
+		startTime: startTime,
+		window:    window,
+		buckets:   make(map[string]map[int64]*timelineBucket),
+		reasons:   make(map[string]map[string]int64),
+	}
+}
+
+// recordErrors adds n failures of op for reason to the error breakdown, the
+// count in the timeline is passed to record.
+func (t *opTimeline) recordErrors(op, reason string, n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byReason, ok := t.reasons[op]
+	if !ok {
+		byReason = make(map[string]int64)
+		t.reasons[op] = byReason
+	}
+	byReason[reason] += int64(n)
+}
+
+func (t *opTimeline) record(op string, latency time.Duration, docs, errors int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*timelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &timelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	b.docs += int64(docs)
+	b.errors += int64(errors)
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls we are looking for.
+func (t *opTimeline) points() []TimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []TimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := TimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Docs = b.docs
+				p.DocsPerSec = float64(b.docs) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = timelinePercentileMs(b.latencies, 50)
+				p.P90Ms = timelinePercentileMs(b.latencies, 90)
+				p.P99Ms = timelinePercentileMs(b.latencies, 99)
+				p.MaxMs = timelinePercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// histogram counts the latencies of the whole run into latencyBucketsMs.
+func (t *opTimeline) histogram() []HistogramBucket {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	for op := range t.buckets {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	var histogram []HistogramBucket
+	for _, op := range ops {
+		counts := make([]int64, len(latencyBucketsMs)+1)
+		for _, b := range t.buckets[op] {
+			for _, latency := range b.latencies {
+				ms := float64(latency) / float64(time.Millisecond)
+				i := sort.SearchFloat64s(latencyBucketsMs, ms)
+				counts[i]++
+			}
+		}
+		for i, count := range counts {
+			le := float64(-1)
+			if i < len(latencyBucketsMs) {
+				le = latencyBucketsMs[i]
+			}
+			histogram = append(histogram, HistogramBucket{Operation: op, LeMs: le, Count: count})
+		}
+	}
+	return histogram
+}
+
+// summarize adds the run wide latency percentiles and error count of every
+// operation type to summary, e.g. bulk_p99_ms.
+func (t *opTimeline) summarize(summary map[string]float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	totalDocs, totalErrors := int64(0), int64(0)
+	for op, byWindow := range t.buckets {
+		var latencies []time.Duration
+		errors := int64(0)
+		for _, b := range byWindow {
+			latencies = append(latencies, b.latencies...)
+			errors += b.errors
+			totalDocs += b.docs
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summary[op+"_p50_ms"] = timelinePercentileMs(latencies, 50)
+		summary[op+"_p90_ms"] = timelinePercentileMs(latencies, 90)
+		summary[op+"_p99_ms"] = timelinePercentileMs(latencies, 99)
+		summary[op+"_errors"] = float64(errors)
+		totalErrors += errors
+	}
+	summary["error_rate_pct"] = errorRatePct(totalDocs, totalErrors)
+}
+
+// windowSummary has the same per operation latency and error rate keys as
+// summarize, plus docs_per_sec, for the last completed window only. It
+// returns nil before the first window is complete.
+func (t *opTimeline) windowSummary() map[string]float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	current := int64(time.Since(t.startTime)/t.window) - 1
+	if current < 0 {
+		return nil
+	}
+	summary := make(map[string]float64)
+	docs, errors := int64(0), int64(0)
+	for op, byWindow := range t.buckets {
+		b, ok := byWindow[current]
+		if !ok {
+			continue
+		}
+		latencies := append([]time.Duration(nil), b.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summary[op+"_p50_ms"] = timelinePercentileMs(latencies, 50)
+		summary[op+"_p90_ms"] = timelinePercentileMs(latencies, 90)
+		summary[op+"_p99_ms"] = timelinePercentileMs(latencies, 99)
+		summary[op+"_errors"] = float64(b.errors)
+		docs += b.docs
+		errors += b.errors
+	}
+	summary["docs_per_sec"] = float64(docs) / t.window.Seconds()
+	summary["error_rate_pct"] = errorRatePct(docs, errors)
+	return summary
+}
+
+func errorRatePct(docs, errors int64) float64 {
+	if docs+errors == 0 {
+		return 0
+	}
+	return float64(errors) / float64(docs+errors) * 100
+}
+
+// watchSLOs checks slos against every completed window until stop is
+// closed. Metrics an operation did not report in a window are skipped.
+func watchSLOs(timeline *opTimeline, slos []sloAssertion, stop chan struct{}, done *sync.WaitGroup, failures *[]string) {
 	defer done.Done()
 
-	domainID := "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
-	workflowTypeName := "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
-	info := "some info"
+	ticker := time.NewTicker(timeline.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		summary := timeline.windowSummary()
+		if summary == nil {
+			continue
+		}
+		offset := time.Since(timeline.startTime).Round(time.Second)
+		for _, failure := range checkSLOs(slos, summary, true) {
+			*failures = append(*failures, fmt.Sprintf("at %v: %s", offset, failure))
+		}
+	}
+}
+
+func (t *opTimeline) errorCounts() []ErrorCount {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errors []ErrorCount
+	for op, byReason := range t.reasons {
+		for reason, count := range byReason {
+			errors = append(errors, ErrorCount{Operation: op, Reason: reason, Count: count})
+		}
+	}
+	sort.Slice(errors, func(i, j int) bool { return errors[i].Count > errors[j].Count })
+	return errors
+}
+
+// progressSnapshot is the run so far, with rates and percentiles of the last
+// completed window over all operation types.
+type progressSnapshot struct {
+	ops        int64
+	docs       int64
+	errors     int64
+	opsPerSec  float64
+	docsPerSec float64
+	p50Ms      float64
+	p99Ms      float64
+}
+
+func (t *opTimeline) snapshot() progressSnapshot {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var p progressSnapshot
+	current := int64(time.Since(t.startTime)/t.window) - 1
+	if current < 0 {
+		current = 0
+	}
+	var latencies []time.Duration
+	var windowDocs int64
+	for _, byWindow := range t.buckets {
+		for w, b := range byWindow {
+			p.ops += int64(len(b.latencies))
+			p.docs += b.docs
+			p.errors += b.errors
+			if w == current {
+				latencies = append(latencies, b.latencies...)
+				windowDocs += b.docs
+			}
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p.opsPerSec = float64(len(latencies)) / t.window.Seconds()
+	p.docsPerSec = float64(windowDocs) / t.window.Seconds()
+	p.p50Ms = timelinePercentileMs(latencies, 50)
+	p.p99Ms = timelinePercentileMs(latencies, 99)
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgress keeps one status line up to date until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func showProgress(timeline *opTimeline, totalOps int64, stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	tty := isTerminal(os.Stdout)
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p := timeline.snapshot()
+		elapsed := time.Since(timeline.startTime)
+		eta := "-"
+		if p.ops > 0 && p.ops < totalOps {
+			eta = (time.Duration(float64(elapsed) * float64(totalOps-p.ops) / float64(p.ops))).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %.0fms  p99 %.0fms  errors %d  eta %s",
+			elapsed.Round(time.Second), p.ops, totalOps, p.opsPerSec, p.docsPerSec, p.p50Ms, p.p99Ms, p.errors, eta)
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+func timelinePercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// sampleTelemetry reads node, cluster and index stats every interval until
+// stop is closed. Indexing rate is derived from the previous sample.
+func sampleTelemetry(client *elastic.Client, domainID string, interval time.Duration, stop chan struct{},
+	done *sync.WaitGroup, samples *[]TelemetrySample) {
+	defer done.Done()
 
 	ctx := context.Background()
-	client, err := elastic.NewClient()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	startTime := time.Now()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := TelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(domainID).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else if index, ok := stats.Indices[domainID]; ok && index.Primaries != nil && index.Primaries.Docs != nil {
+			sample.IndexDocs = index.Primaries.Docs.Count
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+// waitForQuiescence blocks until the index reaches status with no relocating
+// shards and, if waitForMerges is set, until no merges are running on it.
+func waitForQuiescence(client *elastic.Client, domainID, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(domainID).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(domainID).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
 	if err != nil {
-		panic(err)
+		return err
 	}
-	exists, err := client.IndexExists(domainID).Do(ctx)
-	if !exists {
-		fmt.Println("create index ", domainID)
-		createIndex, err := client.CreateIndex(domainID).BodyString(index_bulk_setting).Do(ctx)
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(domainID).Metric("merge").Do(ctx)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		if !createIndex.Acknowledged {
-			// Not acknowledged
+		merges := int64(0)
+		if index, ok := stats.Indices[domainID]; ok && index.Total != nil && index.Total.Merges != nil {
+			merges = index.Total.Merges.Current
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func writeRunReport(report RunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := reportBaseName(report) + ".json"
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+// reportBaseName names the files of a run after its workload and start time,
+// and its trial when there is one, as short trials can start in the same second.
+func reportBaseName(report RunReport) string {
+	name := fmt.Sprintf("./%s-%d", report.Workload, report.StartTime.Unix())
+	if trial, ok := report.Parameters["trial"]; ok {
+		name += fmt.Sprintf("-trial-%v", trial)
+	}
+	return name
+}
+
+// writeTimeline writes the timeline next to the report as CSV, which lines
+// up with the telemetry offsets when plotted.
+func writeTimeline(report RunReport) {
+	filename := reportBaseName(report) + "-timeline.csv"
+	f, err := os.Create(filename)
+	if err != nil {
+		fmt.Println("write timeline failed", err)
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "offset_sec,operation,ops,ops_per_sec,docs,docs_per_sec,errors,p50_ms,p90_ms,p99_ms,max_ms")
+	for _, p := range report.Timeline {
+		fmt.Fprintf(f, "%.0f,%s,%d,%.2f,%d,%.2f,%d,%.2f,%.2f,%.2f,%.2f\n",
+			p.OffsetSec, p.Operation, p.Ops, p.OpsPerSec, p.Docs, p.DocsPerSec, p.Errors,
+			p.P50Ms, p.P90Ms, p.P99Ms, p.MaxMs)
+	}
+	fmt.Println("timeline written to ", filename)
+}
+
+// trialStats computes mean, sample stddev and the 95% confidence interval of
+// every summary metric that all trials have.
+func trialStats(trials []map[string]float64) map[string]TrialStat {
+	stats := make(map[string]TrialStat)
+	n := float64(len(trials))
+	for metric := range trials[0] {
+		var values []float64
+		for _, trial := range trials {
+			if v, ok := trial[metric]; ok {
+				values = append(values, v)
+			}
+		}
+		if len(values) != len(trials) {
+			continue
+		}
+
+		sum := 0.0
+		for _, v := range values {
+			sum += v
 		}
+		mean := sum / n
+		stat := TrialStat{Mean: mean, CI95Low: mean, CI95High: mean}
+		if len(values) > 1 {
+			squares := 0.0
+			for _, v := range values {
+				squares += (v - mean) * (v - mean)
+			}
+			stat.Stddev = math.Sqrt(squares / (n - 1))
+			t := 1.96
+			if len(values)-1 <= len(studentT95) {
+				t = studentT95[len(values)-2]
+			}
+			margin := t * stat.Stddev / math.Sqrt(n)
+			stat.CI95Low = mean - margin
+			stat.CI95High = mean + margin
+		}
+		stats[metric] = stat
+	}
+	return stats
+}
+
+func insertDocBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
+	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration, timeline *opTimeline) {
+	defer done.Done()
+
+	domainID := "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+	workflowTypeName := "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+	info := "some info"
+	labels := promLabels{bulkWorkload, "bulk"}
+
+	client, err := newStressClient()
+	if err != nil {
+		panic(err)
 	}
 
 	bulkUsed := int64(0)
@@ -85,28 +977,47 @@ func insertDocBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
 			fmt.Printf("warning: number of actions is %d\n", bulkRequest.NumberOfActions())
 		}
 
+		stressMetrics.start(labels, bulkRequest.EstimatedSizeInBytes())
 		reqStartTime := time.Now()
 
 		bulkResponse, err := bulkRequest.Do(context.Background())
+		reqTime := time.Since(reqStartTime)
 		if err != nil {
 			fmt.Println("bulk failed", err)
+			stressMetrics.finish(labels, reqTime, 0)
+			timeline.record("bulk", reqTime, 0, batchSize)
+			reason := "request failed"
+			if e, ok := err.(*elastic.Error); ok {
+				reason = "http " + strconv.Itoa(e.Status)
+			}
+			timeline.recordErrors("bulk", reason, batchSize)
+			stressMetrics.addErrors(labels, reason, batchSize)
+		} else {
+			failed := bulkResponse.Failed()
+			stressMetrics.finish(labels, reqTime, batchSize-len(failed))
+			timeline.record("bulk", reqTime, batchSize-len(failed), len(failed))
+			for _, item := range failed {
+				reason := "status " + strconv.Itoa(item.Status)
+				if item.Error != nil {
+					reason = item.Error.Type
+				}
+				timeline.recordErrors("bulk", reason, 1)
+				stressMetrics.addErrors(labels, reason, 1)
+			}
 		}
 
-		timeUsed += time.Since(reqStartTime)
+		timeUsed += reqTime
 
 		if bulkRequest.NumberOfActions() != 0 {
 			fmt.Printf("bulk request not done %d\n", bulkRequest.NumberOfActions())
 		}
 
-		bulkUsed += int64(bulkResponse.Took)
-
-		if t%2000 == 0 {
-			fmt.Println(threadID, t)
+		if bulkResponse != nil {
+			bulkUsed += int64(bulkResponse.Took)
 		}
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 	*bulkTook += bulkUsed / int64(times)
 	*reqUsed += time.Duration(int64(timeUsed) / int64(times))
@@ -135,10 +1046,241 @@ func main() {
 		numOfRequestPerThread = 10
 	}
 
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	var waitForStatus string
+	fmt.Println("Wait for health before start (green/yellow): ")
+	fmt.Scanln(&waitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before start (y/n): ")
+	fmt.Scanln(&waitForMerges)
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
 	if bulkSize <= 0 {
 		bulkSize = 20000
 	}
 
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	if waitForStatus != "green" {
+		waitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+
+	var numOfTrials int
+	fmt.Println("Number of trials: ")
+	fmt.Scanln(&numOfTrials)
+
+	var recreateIndex string
+	fmt.Println("Recreate index before every trial (y/n): ")
+	fmt.Scanln(&recreateIndex)
+
+	if numOfTrials <= 0 {
+		numOfTrials = 1
+	}
+
+	fmt.Println("Client retries on failed requests (0 for none): ")
+	fmt.Scanln(&maxClientRetries)
+
+	var metricsAddr string
+	fmt.Println("Metrics listen address, e.g. :9100 (empty to disable): ")
+	fmt.Scanln(&metricsAddr)
+
+	if metricsAddr != "" {
+		go serveMetrics(metricsAddr)
+	}
+
+	var sloSpec string
+	fmt.Println("SLOs, e.g. bulk_p99_ms<500,error_rate_pct<0.1,docs_per_sec>5000 (empty for none): ")
+	fmt.Scanln(&sloSpec)
+
+	var continuousSLOs string
+	fmt.Println("Check SLOs on every timeline window too (y/n): ")
+	fmt.Scanln(&continuousSLOs)
+
+	slos, err := parseSLOs(sloSpec)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := bulkRunConfig{
+		numOfThread:           numOfThread,
+		numOfRequestPerThread: numOfRequestPerThread,
+		bulkSize:              bulkSize,
+		telemetryInterval:     telemetryInterval,
+		timelineWindow:        timelineWindow,
+		waitForStatus:         waitForStatus,
+		waitForMerges:         waitForMerges == "y",
+		preflightTimeout:      preflightTimeout,
+		recreateIndex:         recreateIndex == "y",
+		numOfTrials:           numOfTrials,
+		sloSpec:               sloSpec,
+		slos:                  slos,
+		continuousSLOs:        continuousSLOs == "y",
+	}
+
+	client, err := newStressClient()
+	if err != nil {
+		panic(err)
+	}
+
+	var trials []map[string]float64
+	var sloFailures []string
+	trialsStartTime := time.Now()
+	for trial := 1; trial <= numOfTrials; trial++ {
+		fmt.Println("------ Trial ", trial, "/", numOfTrials, " ------")
+		report := runBulkTrial(client, cfg, trial)
+		trials = append(trials, report.Summary)
+		for _, failure := range report.SLOFailures {
+			sloFailures = append(sloFailures, fmt.Sprintf("trial %d %s", trial, failure))
+		}
+	}
+	// exits non zero after the trials summary below when an slo failed
+	defer exitOnSLOFailures(slos, sloFailures)
+	if numOfTrials == 1 {
+		return
+	}
+
+	stats := trialStats(trials)
+	var metrics []string
+	for metric := range stats {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	fmt.Println("------ Trials ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "metric\tmean\tstddev\tstddev %\t95% CI\t")
+	summary := make(map[string]float64)
+	for _, metric := range metrics {
+		stat := stats[metric]
+		summary[metric] = stat.Mean
+		relative := 0.0
+		if stat.Mean != 0 {
+			relative = stat.Stddev / stat.Mean * 100
+		}
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.1f%%\t[%.2f, %.2f]\t\n", metric, stat.Mean, stat.Stddev, relative, stat.CI95Low, stat.CI95High)
+	}
+	w.Flush()
+
+	// the trials report has the mean as its summary so it can be compared
+	// like a single run
+	writeRunReport(RunReport{
+		Workload:   bulkWorkload + "-trials",
+		StartTime:  trialsStartTime,
+		Parameters: bulkRunParameters(cfg),
+		Summary:    summary,
+		Trials:     trials,
+		TrialStats: stats,
+	})
+}
+
+func exitOnSLOFailures(slos []sloAssertion, failures []string) {
+	if len(slos) == 0 {
+		return
+	}
+	fmt.Println("------ SLO ------")
+	if len(failures) == 0 {
+		fmt.Println("all slos passed: ", slos)
+		return
+	}
+	fmt.Println(len(failures), " slo failures:")
+	for _, failure := range failures {
+		fmt.Println("  ", failure)
+	}
+	os.Exit(1)
+}
+
+func bulkRunParameters(cfg bulkRunConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"go_routines":          cfg.numOfThread,
+		"requests_per_routine": cfg.numOfRequestPerThread,
+		"bulk_size":            cfg.bulkSize,
+		"telemetry_interval_s": cfg.telemetryInterval,
+		"timeline_window_s":    cfg.timelineWindow,
+		"wait_for_status":      cfg.waitForStatus,
+		"wait_for_merges":      cfg.waitForMerges,
+		"recreate_index":       cfg.recreateIndex,
+		"trials":               cfg.numOfTrials,
+		"client_retries":       maxClientRetries,
+		"slos":                 cfg.sloSpec,
+		"continuous_slos":      cfg.continuousSLOs,
+	}
+}
+
+// runBulkTrial prepares the index, runs one bulk insert and writes its report.
+func runBulkTrial(client *elastic.Client, cfg bulkRunConfig, trial int) RunReport {
+	numOfThread := cfg.numOfThread
+	numOfRequestPerThread := cfg.numOfRequestPerThread
+	bulkSize := cfg.bulkSize
+
+	domainID := "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+	ctx := context.Background()
+	exists, err := client.IndexExists(domainID).Do(ctx)
+	if exists && cfg.recreateIndex {
+		fmt.Println("delete index ", domainID)
+		if _, err := client.DeleteIndex(domainID).Do(ctx); err != nil {
+			panic(err)
+		}
+		exists = false
+	}
+	if !exists {
+		fmt.Println("create index ", domainID)
+		createIndex, err := client.CreateIndex(domainID).BodyString(index_bulk_setting).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	err = waitForQuiescence(client, domainID, cfg.waitForStatus, cfg.waitForMerges, time.Duration(cfg.preflightTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	runStartTime := time.Now()
+	timeline := newOpTimeline(runStartTime, time.Duration(cfg.timelineWindow)*time.Second)
+	var telemetry []TelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleTelemetry(client, domainID, time.Duration(cfg.telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go showProgress(timeline, int64(numOfThread*numOfRequestPerThread), stopProgress, &progress)
+
+	var sloFailures []string
+	var sloWatcher sync.WaitGroup
+	stopSLOs := make(chan struct{})
+	if cfg.continuousSLOs && len(cfg.slos) > 0 {
+		sloWatcher.Add(1)
+		go watchSLOs(timeline, cfg.slos, stopSLOs, &sloWatcher, &sloFailures)
+	}
+
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -146,11 +1288,50 @@ func main() {
 	var reqUsed time.Duration
 	var bulkTook int64
 	for i := 0; i < numOfThread; i += 1 {
-		go insertDocBulk(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed)
+		go insertDocBulk(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed, timeline)
 	}
 	done.Wait()
-	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
-	fmt.Println("avg time on request: ", time.Duration(int64(durationWithoutPrep)/int64(numOfThread)))
-	fmt.Println("avg bulk took: ", bulkTook/int64(numOfThread))
-	fmt.Println("avg req took: ", time.Duration(int64(reqUsed)/int64(numOfThread)))
+	runTime := time.Since(runStartTime)
+	close(stopProgress)
+	progress.Wait()
+	close(stopSLOs)
+	sloWatcher.Wait()
+	close(stopSampling)
+	sampler.Wait()
+
+	avgTime := time.Duration(int64(duration) / int64(numOfThread))
+	avgTimeOnRequest := time.Duration(int64(durationWithoutPrep) / int64(numOfThread))
+	avgBulkTook := bulkTook / int64(numOfThread)
+	avgReqTook := time.Duration(int64(reqUsed) / int64(numOfThread))
+	fmt.Println("avg time: ", avgTime)
+	fmt.Println("avg time on request: ", avgTimeOnRequest)
+	fmt.Println("avg bulk took: ", avgBulkTook)
+	fmt.Println("avg req took: ", avgReqTook)
+
+	report := RunReport{
+		Workload:   bulkWorkload,
+		StartTime:  runStartTime,
+		Parameters: bulkRunParameters(cfg),
+		Summary: map[string]float64{
+			"run_time_ms":            float64(runTime / time.Millisecond),
+			"avg_time_ms":            float64(avgTime / time.Millisecond),
+			"avg_time_on_request_ms": float64(avgTimeOnRequest / time.Millisecond),
+			"avg_bulk_took_ms":       float64(avgBulkTook),
+			"avg_req_took_ms":        float64(avgReqTook / time.Millisecond),
+		},
+		Telemetry: telemetry,
+		Timeline:  timeline.points(),
+		Histogram: timeline.histogram(),
+		Errors:    timeline.errorCounts(),
+	}
+	report.Parameters["trial"] = trial
+	timeline.summarize(report.Summary)
+	// count only the docs that were written, a failed bulk or a failed item
+	// is in bulk_errors
+	attemptedDocs := float64(numOfThread * numOfRequestPerThread * bulkSize)
+	report.Summary["docs_per_sec"] = (attemptedDocs - report.Summary["bulk_errors"]) / runTime.Seconds()
+	report.SLOFailures = append(sloFailures, checkSLOs(cfg.slos, report.Summary, false)...)
+	writeRunReport(report)
+	writeTimeline(report)
+	return report
 }
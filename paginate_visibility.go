@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+const paginateDomainID = "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+const paginateWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+// run_id is dynamically mapped as text, so sort on its keyword sub field
+const paginateTieBreaker = "run_id.keyword"
+
+func paginateQuery(low, high int64) elastic.Query {
+	matchQuery := elastic.NewMatchQuery("workflow_type_name", paginateWorkflowTypeName)
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lte(high)
+	return elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+}
+
+// pageFromSize walks numOfPages pages with from/size and returns the latency
+// of every page. It stops early once the result window is exceeded.
+func pageFromSize(ctx context.Context, client *elastic.Client, query elastic.Query, numOfPages, pagesize int) []time.Duration {
+	var latencies []time.Duration
+	for p := 0; p < numOfPages; p++ {
+		reqStartTime := time.Now()
+		searchResult, err := client.Search().Index(paginateDomainID).Query(query).
+			Sort("close_time", false).
+			From(p * pagesize).Size(pagesize).
+			Do(ctx)
+		if err != nil {
+			fmt.Println("from/size stopped at page ", p+1, " err: ", err)
+			break
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+		if len(searchResult.Hits.Hits) < pagesize {
+			break
+		}
+	}
+	return latencies
+}
+
+// pageSearchAfter walks numOfPages pages by passing the sort values of the
+// last hit of a page as search_after of the next one, like Cadence does.
+func pageSearchAfter(ctx context.Context, client *elastic.Client, query elastic.Query, numOfPages, pagesize int) []time.Duration {
+	var latencies []time.Duration
+	var sortValues []interface{}
+	for p := 0; p < numOfPages; p++ {
+		search := client.Search().Index(paginateDomainID).Query(query).
+			SortBy(elastic.NewFieldSort("close_time").Desc(), elastic.NewFieldSort(paginateTieBreaker).Asc()).
+			Size(pagesize)
+		if sortValues != nil {
+			search = search.SearchAfter(sortValues...)
+		}
+
+		reqStartTime := time.Now()
+		searchResult, err := search.Do(ctx)
+		if err != nil {
+			fmt.Println("search_after stopped at page ", p+1, " err: ", err)
+			break
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+		hits := searchResult.Hits.Hits
+		if len(hits) < pagesize {
+			break
+		}
+		sortValues = hits[len(hits)-1].Sort
+	}
+	return latencies
+}
+
+// pageScroll walks numOfPages pages with a sorted scroll and clears it
+// afterwards.
+func pageScroll(ctx context.Context, client *elastic.Client, query elastic.Query, numOfPages, pagesize int) []time.Duration {
+	var latencies []time.Duration
+	scroll := client.Scroll().Index(paginateDomainID).Query(query).
+		Sort("close_time", false).Size(pagesize)
+	defer scroll.Clear(ctx)
+
+	for p := 0; p < numOfPages; p++ {
+		reqStartTime := time.Now()
+		results, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("scroll stopped at page ", p+1, " err: ", err)
+			break
+		}
+		latencies = append(latencies, time.Since(reqStartTime))
+		if len(results.Hits.Hits) < pagesize {
+			break
+		}
+	}
+	return latencies
+}
+
+// addPageLatencies adds the latencies of one walk into the per depth totals.
+func addPageLatencies(total []time.Duration, count []int, latencies []time.Duration) {
+	for i, l := range latencies {
+		total[i] += l
+		count[i]++
+	}
+}
+
+func avgPageLatency(total []time.Duration, count []int, depth int) string {
+	if count[depth] == 0 {
+		return "-"
+	}
+	return time.Duration(int64(total[depth]) / int64(count[depth])).String()
+}
+
+func main() {
+	var times int
+	fmt.Println("Number of walks per method: ")
+	fmt.Scanln(&times)
+
+	var numOfPages int
+	fmt.Println("Number of pages per walk: ")
+	fmt.Scanln(&numOfPages)
+
+	var pageSize int
+	fmt.Println("Page size: ")
+	fmt.Scanln(&pageSize)
+
+	if times <= 0 {
+		times = 1
+	}
+
+	if numOfPages <= 0 {
+		numOfPages = 100
+	}
+
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	fromSizeTotal := make([]time.Duration, numOfPages)
+	fromSizeCount := make([]int, numOfPages)
+	searchAfterTotal := make([]time.Duration, numOfPages)
+	searchAfterCount := make([]int, numOfPages)
+	scrollTotal := make([]time.Duration, numOfPages)
+	scrollCount := make([]int, numOfPages)
+
+	for i := 0; i < times; i++ {
+		millis := time.Now().UnixNano() / 1e6
+		query := paginateQuery(0, millis)
+		addPageLatencies(fromSizeTotal, fromSizeCount, pageFromSize(ctx, client, query, numOfPages, pageSize))
+		addPageLatencies(searchAfterTotal, searchAfterCount, pageSearchAfter(ctx, client, query, numOfPages, pageSize))
+		addPageLatencies(scrollTotal, scrollCount, pageScroll(ctx, client, query, numOfPages, pageSize))
+	}
+
+	step := numOfPages / 20
+	if step < 1 {
+		step = 1
+	}
+
+	fmt.Println("------ Pagination Latency By Depth ------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "page\tfrom/size\tsearch_after\tscroll\t")
+	for depth := 0; depth < numOfPages; depth++ {
+		if depth != 0 && (depth+1)%step != 0 && depth != numOfPages-1 {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t\n", depth+1,
+			avgPageLatency(fromSizeTotal, fromSizeCount, depth),
+			avgPageLatency(searchAfterTotal, searchAfterCount, depth),
+			avgPageLatency(scrollTotal, scrollCount, depth))
+	}
+	w.Flush()
+}
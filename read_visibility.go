@@ -2,13 +2,339 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/olivere/elastic"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-func read_visibility(low, high int64, from, pagesize int) (int64, int64) {
+const read_domain_id = "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+
+// slo_assertion is one pass/fail criterion on a list metric, e.g.
+// list_p99_ms<200 or error_rate_pct<0.1, same as the bulk runner's.
+type slo_assertion struct {
+	metric    string
+	less_than bool
+	limit     float64
+}
+
+func (a slo_assertion) String() string {
+	if a.less_than {
+		return fmt.Sprintf("%s<%g", a.metric, a.limit)
+	}
+	return fmt.Sprintf("%s>%g", a.metric, a.limit)
+}
+
+func parse_slos(spec string) ([]slo_assertion, error) {
+	var slos []slo_assertion
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.IndexAny(part, "<>")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid slo %q, want metric<limit or metric>limit", part)
+		}
+		limit, err := strconv.ParseFloat(part[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit in slo %q: %v", part, err)
+		}
+		slos = append(slos, slo_assertion{metric: part[:i], less_than: part[i] == '<', limit: limit})
+	}
+	return slos, nil
+}
+
+func check_slos(slos []slo_assertion, summary map[string]float64) []string {
+	var failures []string
+	for _, slo := range slos {
+		value, ok := summary[slo.metric]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: metric not reported", slo))
+			continue
+		}
+		if (slo.less_than && value >= slo.limit) || (!slo.less_than && value <= slo.limit) {
+			failures = append(failures, fmt.Sprintf("%s: was %.2f", slo, value))
+		}
+	}
+	return failures
+}
+
+// list_summary has the metrics slos can be set on for a set of list requests
+// that took elapsed in total.
+func list_summary(latencies []time.Duration, errors int, elapsed time.Duration) map[string]float64 {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p int) float64 {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return float64(latencies[(len(latencies)-1)*p/100]) / float64(time.Millisecond)
+	}
+	summary := map[string]float64{
+		"list_p50_ms":    percentile(50),
+		"list_p90_ms":    percentile(90),
+		"list_p99_ms":    percentile(99),
+		"list_errors":    float64(errors),
+		"error_rate_pct": 0,
+		"lists_per_sec":  float64(len(latencies)) / elapsed.Seconds(),
+	}
+	if len(latencies)+errors > 0 {
+		summary["error_rate_pct"] = float64(errors) / float64(len(latencies)+errors) * 100
+	}
+	return summary
+}
+
+// timeline_point is what one operation type did within one window of the run,
+// like TimelinePoint in insert_visibility_bulk.go.
+type timeline_point struct {
+	OffsetSec float64 `json:"offset_sec"`
+	Operation string  `json:"operation"`
+	Ops       int64   `json:"ops"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	Errors    int64   `json:"errors"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+}
+
+type timeline_bucket struct {
+	latencies []time.Duration
+	errors    int64
+}
+
+// op_timeline buckets every request by operation type and by the window it
+// finished in.
+type op_timeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*timeline_bucket
+}
+
+var list_timeline = &op_timeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *op_timeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*timeline_bucket)
+}
+
+func (t *op_timeline) record(op string, latency time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*timeline_bucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &timeline_bucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	if err != nil {
+		b.errors++
+	}
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *op_timeline) points() []timeline_point {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []timeline_point
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := timeline_point{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = timeline_percentile_ms(b.latencies, 50)
+				p.P90Ms = timeline_percentile_ms(b.latencies, 90)
+				p.P99Ms = timeline_percentile_ms(b.latencies, 99)
+				p.MaxMs = timeline_percentile_ms(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func timeline_percentile_ms(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// telemetry_sample is one sample of the cluster during the run, with the
+// fields of TelemetrySample in insert_visibility_bulk.go.
+type telemetry_sample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// run_report is written as JSON at the end of a run in the layout of the
+// insert_visibility_bulk.go run report, so report_html.go can show it.
+type run_report struct {
+	Workload   string                 `json:"workload"`
+	StartTime  time.Time              `json:"start_time"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Summary    map[string]float64     `json:"summary"`
+	Telemetry  []telemetry_sample     `json:"telemetry"`
+	Timeline   []timeline_point       `json:"timeline"`
+}
+
+// sample_telemetry reads node, cluster and index stats every interval until
+// stop is closed. Offsets are relative to startTime and indexing rate is
+// derived from the previous sample.
+func sample_telemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]telemetry_sample) {
+	defer done.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := telemetry_sample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func write_run_report(report run_report) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+func read_visibility(low, high int64, from, pagesize int) (int64, int64, error) {
 	ctx := context.Background()
 
 	client, err := elastic.NewClient()
@@ -16,23 +342,22 @@ func read_visibility(low, high int64, from, pagesize int) (int64, int64) {
 		panic(err)
 	}
 
-	domainID := "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
 	workflowTypeName := "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
 
 	matchQuery := elastic.NewMatchQuery("workflow_type_name", workflowTypeName)
 	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lte(high)
 	boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
 
-	searchResult, err := client.Search().Index(domainID).Query(boolQuery).
+	searchResult, err := client.Search().Index(read_domain_id).Query(boolQuery).
 		Sort("close_time", false).
 		From(from).Size(pagesize).
 		Pretty(true).
 		Do(ctx)
 	if err != nil {
-		panic(err)
+		return 0, 0, err
 	}
 
-	return searchResult.TookInMillis, searchResult.TotalHits()
+	return searchResult.TookInMillis, searchResult.TotalHits(), nil
 }
 
 func main() {
@@ -40,18 +365,122 @@ func main() {
 	fmt.Println("Number of requests: ")
 	fmt.Scanln(&times)
 
+	var sloSpec string
+	fmt.Println("SLOs, e.g. list_p99_ms<200,error_rate_pct<0.1,lists_per_sec>50 (empty for none): ")
+	fmt.Scanln(&sloSpec)
+
+	var continuousSLOs string
+	fmt.Println("Check SLOs every second too (y/n): ")
+	fmt.Scanln(&continuousSLOs)
+
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	slos, err := parse_slos(sloSpec)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
 	var totalTime int64
 	var totalHits int64
+	var latencies []time.Duration
+	var errors int
+	var failures []string
+	var windowLatencies []time.Duration
+	var windowErrors int
+	startTime := time.Now()
+	list_timeline.start(startTime, time.Duration(timelineWindow)*time.Second)
+	windowStartTime := startTime
+	var telemetry []telemetry_sample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sample_telemetry(client, []string{read_domain_id}, startTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
 	for i := 0; i < times; i += 1 {
 		millis := time.Now().UnixNano() / 1e6
 		src := rand.NewSource(millis)
 		r := rand.New(src)
-		t, h := read_visibility(millis-3600000, millis, r.Intn(10), 10)
+		reqStartTime := time.Now()
+		t, h, err := read_visibility(millis-3600000, millis, r.Intn(10), 10)
+		list_timeline.record("list", time.Since(reqStartTime), err)
+		if err != nil {
+			fmt.Println("read failed ", err)
+			errors++
+			windowErrors++
+		} else {
+			latencies = append(latencies, time.Since(reqStartTime))
+			windowLatencies = append(windowLatencies, time.Since(reqStartTime))
+		}
 		//fmt.Println(t)
 		totalTime += t
 		totalHits += h
+
+		if windowElapsed := time.Since(windowStartTime); windowElapsed >= time.Second {
+			if continuousSLOs == "y" {
+				offset := time.Since(startTime).Round(time.Second)
+				for _, failure := range check_slos(slos, list_summary(windowLatencies, windowErrors, windowElapsed)) {
+					failures = append(failures, fmt.Sprintf("at %v: %s", offset, failure))
+				}
+			}
+			windowLatencies = nil
+			windowErrors = 0
+			windowStartTime = time.Now()
+		}
 	}
+	elapsedTime := time.Since(startTime)
+	close(stopSampling)
+	sampler.Wait()
 
 	fmt.Println("avg read time millis: ", totalTime/int64(times))
 	fmt.Println("avg hits: ", totalHits/int64(times))
+
+	summary := list_summary(latencies, errors, elapsedTime)
+	write_run_report(run_report{
+		Workload:  "read_visibility",
+		StartTime: startTime,
+		Parameters: map[string]interface{}{
+			"requests":             times,
+			"slos":                 sloSpec,
+			"continuous_slos":      continuousSLOs == "y",
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Summary:   summary,
+		Telemetry: telemetry,
+		Timeline:  list_timeline.points(),
+	})
+
+	if len(slos) == 0 {
+		return
+	}
+	failures = append(failures, check_slos(slos, summary)...)
+	fmt.Println("------ SLO ------")
+	fmt.Println("list p99 millis: ", summary["list_p99_ms"], " error rate %: ", summary["error_rate_pct"], " lists/sec: ", summary["lists_per_sec"])
+	if len(failures) == 0 {
+		fmt.Println("all slos passed: ", slos)
+		return
+	}
+	fmt.Println(len(failures), " slo failures:")
+	for _, failure := range failures {
+		fmt.Println("  ", failure)
+	}
+	os.Exit(1)
 }
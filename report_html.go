@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlRunReport is the part of the run report written by
+// insert_visibility_bulk.go that the HTML report shows.
+type htmlRunReport struct {
+	Workload   string                 `json:"workload"`
+	StartTime  time.Time              `json:"start_time"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Summary    map[string]float64     `json:"summary"`
+	Telemetry  []htmlTelemetrySample  `json:"telemetry"`
+	Timeline   []htmlTimelinePoint    `json:"timeline"`
+	Histogram  []htmlHistogramBucket  `json:"histogram"`
+	Errors     []htmlErrorCount       `json:"errors"`
+}
+
+type htmlTelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	SegmentCount    int64   `json:"segment_count"`
+}
+
+type htmlTimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+}
+
+type htmlHistogramBucket struct {
+	Operation string  `json:"operation"`
+	LeMs      float64 `json:"le_ms"`
+	Count     int64   `json:"count"`
+}
+
+type htmlErrorCount struct {
+	Operation string `json:"operation"`
+	Reason    string `json:"reason"`
+	Count     int64  `json:"count"`
+}
+
+type chartSeries struct {
+	name   string
+	points [][2]float64
+}
+
+var chartColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+const (
+	chartWidth  = 900
+	chartHeight = 260
+	chartLeft   = 60
+	chartRight  = 60
+	chartTop    = 30
+	chartBottom = 30
+)
+
+func seriesMax(series []chartSeries) float64 {
+	max := 0.0
+	for _, s := range series {
+		for _, p := range s.points {
+			if p[1] > max {
+				max = p[1]
+			}
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+	return max
+}
+
+// svgLineChart draws series against the left axis and overlay, if any,
+// dashed against its own right axis so telemetry can share the time axis.
+func svgLineChart(title string, xMax float64, series, overlay []chartSeries) template.HTML {
+	if xMax <= 0 {
+		xMax = 1
+	}
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	x := func(v float64) float64 { return chartLeft + v/xMax*plotWidth }
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg" font-size="11">`, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="16" font-size="13" font-weight="bold">%s</text>`, chartLeft, html.EscapeString(title))
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.0f" height="%.0f" fill="none" stroke="#ccc"/>`, chartLeft, chartTop, plotWidth, plotHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="%d">0s</text>`, chartLeft, chartHeight-10)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%.0fs</text>`, chartWidth-chartRight, chartHeight-10, xMax)
+
+	draw := func(all []chartSeries, colorOffset int, dashed bool, axisX int, anchor string) {
+		if len(all) == 0 {
+			return
+		}
+		yMax := seriesMax(all)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="%s">%.1f</text>`, axisX, chartTop+4, anchor, yMax)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="%s">0</text>`, axisX, chartHeight-chartBottom, anchor)
+		for i, s := range all {
+			color := chartColors[(i+colorOffset)%len(chartColors)]
+			var coords []string
+			for _, p := range s.points {
+				y := chartTop + plotHeight - p[1]/yMax*plotHeight
+				coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(p[0]), y))
+			}
+			dash := ""
+			if dashed {
+				dash = ` stroke-dasharray="4,3"`
+			}
+			fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5"%s points="%s"/>`, color, dash, strings.Join(coords, " "))
+		}
+	}
+	draw(series, 0, false, chartLeft-4, "end")
+	draw(overlay, len(series), true, chartWidth-chartRight+4, "start")
+	b.WriteString(`</svg>`)
+
+	// legend
+	b.WriteString(`<div class="legend">`)
+	for i, s := range append(append([]chartSeries{}, series...), overlay...) {
+		style := "solid"
+		if i >= len(series) {
+			style = "dashed, right axis"
+		}
+		fmt.Fprintf(&b, `<span><i style="background:%s"></i>%s (%s)</span>`,
+			chartColors[i%len(chartColors)], html.EscapeString(s.name), style)
+	}
+	b.WriteString(`</div>`)
+	return template.HTML(b.String())
+}
+
+func svgBarChart(title string, labels []string, values []int64) template.HTML {
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	max := int64(1)
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg" font-size="11">`, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="16" font-size="13" font-weight="bold">%s</text>`, chartLeft, html.EscapeString(title))
+	barWidth := plotWidth / float64(len(values))
+	for i, v := range values {
+		h := float64(v) / float64(max) * plotHeight
+		bx := chartLeft + float64(i)*barWidth
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+			bx+2, chartTop+plotHeight-h, barWidth-4, h, chartColors[0])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`, bx+barWidth/2, chartTop+plotHeight-h-3, v)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, bx+barWidth/2, chartHeight-10, html.EscapeString(labels[i]))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// timelineSeries splits the timeline into one series per operation type.
+func timelineSeries(timeline []htmlTimelinePoint, suffix string, value func(htmlTimelinePoint) float64) []chartSeries {
+	byOp := make(map[string]*chartSeries)
+	var ops []string
+	for _, p := range timeline {
+		s, ok := byOp[p.Operation]
+		if !ok {
+			s = &chartSeries{name: p.Operation + " " + suffix}
+			byOp[p.Operation] = s
+			ops = append(ops, p.Operation)
+		}
+		s.points = append(s.points, [2]float64{p.OffsetSec, value(p)})
+	}
+	var series []chartSeries
+	for _, op := range ops {
+		series = append(series, *byOp[op])
+	}
+	return series
+}
+
+// telemetrySeries plots a telemetry field. Counters that are cumulative
+// since node start are plotted as the increase since the previous sample.
+func telemetrySeries(samples []htmlTelemetrySample, name string, cumulative bool, value func(htmlTelemetrySample) float64) chartSeries {
+	s := chartSeries{name: name}
+	for i, sample := range samples {
+		v := value(sample)
+		if cumulative {
+			if i == 0 {
+				continue
+			}
+			v -= value(samples[i-1])
+		}
+		s.points = append(s.points, [2]float64{sample.OffsetSec, v})
+	}
+	return s
+}
+
+type htmlKeyValue struct {
+	Key   string
+	Value string
+}
+
+type htmlHistogramChart struct {
+	Operation string
+	Chart     template.HTML
+}
+
+type htmlReportPage struct {
+	Report       htmlRunReport
+	Parameters   []htmlKeyValue
+	Summary      []htmlKeyValue
+	Throughput   template.HTML
+	Latency      template.HTML
+	ErrorChart   template.HTML
+	ClusterChart template.HTML
+	Histograms   []htmlHistogramChart
+	StatusChange []string
+}
+
+const report_html_template = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Report.Workload}} {{.Report.StartTime.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+.legend span { margin-right: 16px; font-size: 12px; }
+.legend i { display: inline-block; width: 12px; height: 3px; margin-right: 4px; vertical-align: middle; }
+section { margin-bottom: 32px; }
+</style>
+</head>
+<body>
+<h1>{{.Report.Workload}}</h1>
+<p>started {{.Report.StartTime.Format "2006-01-02 15:04:05 MST"}}</p>
+
+<section>
+<h2>Parameters</h2>
+<table>{{range .Parameters}}<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>{{end}}</table>
+<h2>Summary</h2>
+<table>{{range .Summary}}<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>{{end}}</table>
+</section>
+
+<section>
+<h2>Throughput</h2>
+{{.Throughput}}
+</section>
+
+<section>
+<h2>Latency</h2>
+{{.Latency}}
+{{range .Histograms}}{{.Chart}}{{end}}
+</section>
+
+<section>
+<h2>Errors</h2>
+{{.ErrorChart}}
+{{if .Report.Errors}}<table><tr><th>operation</th><th>reason</th><th>count</th></tr>
+{{range .Report.Errors}}<tr><td>{{.Operation}}</td><td>{{.Reason}}</td><td>{{.Count}}</td></tr>{{end}}
+</table>{{else}}<p>no errors</p>{{end}}
+</section>
+
+<section>
+<h2>Cluster</h2>
+{{.ClusterChart}}
+{{if .StatusChange}}<ul>{{range .StatusChange}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</section>
+</body>
+</html>
+`
+
+func buildReportPage(report htmlRunReport) htmlReportPage {
+	page := htmlReportPage{Report: report}
+
+	var keys []string
+	for k := range report.Parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		page.Parameters = append(page.Parameters, htmlKeyValue{k, fmt.Sprint(report.Parameters[k])})
+	}
+	keys = nil
+	for k := range report.Summary {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		page.Summary = append(page.Summary, htmlKeyValue{k, fmt.Sprintf("%.2f", report.Summary[k])})
+	}
+
+	xMax := 0.0
+	for _, p := range report.Timeline {
+		if p.OffsetSec > xMax {
+			xMax = p.OffsetSec
+		}
+	}
+	for _, s := range report.Telemetry {
+		if s.OffsetSec > xMax {
+			xMax = s.OffsetSec
+		}
+	}
+
+	t := report.Telemetry
+	page.Throughput = svgLineChart("docs/sec", xMax,
+		timelineSeries(report.Timeline, "docs/sec", func(p htmlTimelinePoint) float64 { return p.DocsPerSec }),
+		[]chartSeries{
+			telemetrySeries(t, "write queue", false, func(s htmlTelemetrySample) float64 { return float64(s.WriteQueue) }),
+			telemetrySeries(t, "write rejected", true, func(s htmlTelemetrySample) float64 { return float64(s.WriteRejected) }),
+		})
+
+	latency := timelineSeries(report.Timeline, "p50 ms", func(p htmlTimelinePoint) float64 { return p.P50Ms })
+	latency = append(latency, timelineSeries(report.Timeline, "p99 ms", func(p htmlTimelinePoint) float64 { return p.P99Ms })...)
+	page.Latency = svgLineChart("request latency", xMax, latency,
+		[]chartSeries{
+			telemetrySeries(t, "gc ms", true, func(s htmlTelemetrySample) float64 { return float64(s.GCTimeMillis) }),
+			telemetrySeries(t, "merges", false, func(s htmlTelemetrySample) float64 { return float64(s.MergesCurrent) }),
+		})
+
+	page.ErrorChart = svgLineChart("errors per window", xMax,
+		timelineSeries(report.Timeline, "errors", func(p htmlTimelinePoint) float64 { return float64(p.Errors) }),
+		[]chartSeries{
+			telemetrySeries(t, "search rejected", true, func(s htmlTelemetrySample) float64 { return float64(s.SearchRejected) }),
+		})
+
+	page.ClusterChart = svgLineChart("cluster", xMax,
+		[]chartSeries{
+			telemetrySeries(t, "indexing rate", false, func(s htmlTelemetrySample) float64 { return s.IndexingRate }),
+		},
+		[]chartSeries{
+			telemetrySeries(t, "heap used %", false, func(s htmlTelemetrySample) float64 { return float64(s.HeapUsedPercent) }),
+			telemetrySeries(t, "segments", false, func(s htmlTelemetrySample) float64 { return float64(s.SegmentCount) }),
+		})
+
+	lastStatus := ""
+	for _, s := range t {
+		if s.ClusterStatus != "" && s.ClusterStatus != lastStatus {
+			page.StatusChange = append(page.StatusChange, fmt.Sprintf("%.0fs cluster status %s", s.OffsetSec, s.ClusterStatus))
+			lastStatus = s.ClusterStatus
+		}
+	}
+
+	var ops []string
+	labels := make(map[string][]string)
+	values := make(map[string][]int64)
+	for _, h := range report.Histogram {
+		if _, ok := values[h.Operation]; !ok {
+			ops = append(ops, h.Operation)
+		}
+		label := fmt.Sprintf("<=%.0fms", h.LeMs)
+		if h.LeMs < 0 {
+			label = "slower"
+		}
+		labels[h.Operation] = append(labels[h.Operation], label)
+		values[h.Operation] = append(values[h.Operation], h.Count)
+	}
+	for _, op := range ops {
+		page.Histograms = append(page.Histograms, htmlHistogramChart{
+			Operation: op,
+			Chart:     svgBarChart(op+" latency histogram", labels[op], values[op]),
+		})
+	}
+	return page
+}
+
+func main() {
+	var filename string
+	if len(os.Args) > 1 {
+		filename = os.Args[1]
+	} else {
+		fmt.Println("Run report file: ")
+		fmt.Scanln(&filename)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	var report htmlRunReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		panic(err)
+	}
+
+	tmpl := template.Must(template.New("report").Parse(report_html_template))
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, buildReportPage(report)); err != nil {
+		panic(err)
+	}
+
+	htmlFile := strings.TrimSuffix(filename, ".json") + ".html"
+	if err := ioutil.WriteFile(htmlFile, out.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	fmt.Println("html report written to ", htmlFile)
+}
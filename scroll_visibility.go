@@ -6,9 +6,50 @@ import (
 	"github.com/olivere/elastic"
 	"time"
 	"io"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 )
 
+// scrolls started by this tool and not cleared yet, so they can be released
+// on SIGINT instead of piling up on the cluster until keep alive ends. The
+// service is tracked rather than ids from results because Do keeps the scroll
+// id but returns no result when a page is empty.
+var open_scroll_lock sync.Mutex
+var open_scrolls = make(map[*elastic.ScrollService]bool)
+
+func track_scroll(scroll *elastic.ScrollService) {
+	open_scroll_lock.Lock()
+	open_scrolls[scroll] = true
+	open_scroll_lock.Unlock()
+}
+
+// release_scroll clears the scroll context and stops tracking it.
+func release_scroll(ctx context.Context, scroll *elastic.ScrollService) {
+	if err := scroll.Clear(ctx); err != nil {
+		fmt.Println("clear scroll err: ", err)
+	}
+	open_scroll_lock.Lock()
+	delete(open_scrolls, scroll)
+	open_scroll_lock.Unlock()
+}
+
+// clear_tracked_scrolls clears every scroll still open and returns how many
+// there were.
+func clear_tracked_scrolls() int {
+	open_scroll_lock.Lock()
+	var scrolls []*elastic.ScrollService
+	for scroll := range open_scrolls {
+		scrolls = append(scrolls, scroll)
+	}
+	open_scroll_lock.Unlock()
+	for _, scroll := range scrolls {
+		release_scroll(context.Background(), scroll)
+	}
+	return len(scrolls)
+}
+
 func scroll_visibility(low, high int64, pagesize int) (int64, int64, int64, int64) {
 	return scroll_helper(low, high, pagesize, false)
 }
@@ -45,6 +86,9 @@ func scroll_helper(low, high int64, pagesize int, sorted bool) (int64, int64, in
 		scroll = client.Scroll().Index(domainID).Query(boolQuery).Size(pagesize)
 	}
 
+	track_scroll(scroll)
+	defer release_scroll(ctx, scroll)
+
 	i := int64(0)
 	for {
 		i++
@@ -74,6 +118,95 @@ func scroll_helper(low, high int64, pagesize int, sorted bool) (int64, int64, in
 	return tookInMillis, totalHits, avgTook, maxTook
 }
 
+// scroll_visibility_sliced scans the range with numOfSlices sliced scrolls
+// processed concurrently and returns the hits and the time it took.
+func scroll_visibility_sliced(low, high int64, pagesize, numOfSlices int) (int64, time.Duration) {
+	ctx := context.Background()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	domainID := "bulk4ea2-69f9-4495-a1b2-6ea71b5fa459"
+	workflowTypeName := "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+	matchQuery := elastic.NewMatchQuery("workflow_type_name", workflowTypeName)
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lte(high)
+	boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+
+	var done sync.WaitGroup
+	var lock sync.Mutex
+	var totalHits int64
+	done.Add(numOfSlices)
+	startTime := time.Now()
+	for s := 0; s < numOfSlices; s++ {
+		sliceQuery := elastic.NewSliceQuery().Id(s).Max(numOfSlices)
+		scroll := client.Scroll().Index(domainID).Query(boolQuery).Slice(sliceQuery).Size(pagesize)
+		go func() {
+			defer done.Done()
+			track_scroll(scroll)
+			defer release_scroll(ctx, scroll)
+
+			hits := int64(0)
+			for {
+				results, err := scroll.Do(ctx)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					fmt.Println("sliced scroll err: ", err)
+					break
+				}
+				hits += int64(len(results.Hits.Hits))
+			}
+			lock.Lock()
+			totalHits += hits
+			lock.Unlock()
+		}()
+	}
+	done.Wait()
+	return totalHits, time.Since(startTime)
+}
+
+// open_scroll_contexts sums search.open_contexts over all nodes.
+func open_scroll_contexts(client *elastic.Client) int64 {
+	res, err := client.NodesStats().Metric("indices").IndexMetric("search").Do(context.Background())
+	if err != nil {
+		fmt.Println("nodes stats err: ", err)
+		return -1
+	}
+	var open int64
+	for _, node := range res.Nodes {
+		if node.Indices != nil && node.Indices.Search != nil {
+			open += node.Indices.Search.OpenContexts
+		}
+	}
+	return open
+}
+
+// sample_open_contexts prints search.open_contexts every interval until stop
+// is closed and keeps the peak in maxOpen.
+func sample_open_contexts(client *elastic.Client, interval time.Duration, stop chan struct{},
+	done *sync.WaitGroup, maxOpen *int64) {
+	defer done.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			open := open_scroll_contexts(client)
+			fmt.Println("open scroll contexts: ", open)
+			if open > *maxOpen {
+				*maxOpen = open
+			}
+		}
+	}
+}
+
 func main() {
 	var times int
 	fmt.Println("Number of requests: ")
@@ -83,9 +216,40 @@ func main() {
 	fmt.Println( "Page size: ")
 	fmt.Scanln(&pageSize)
 
+	var numOfSlices int
+	fmt.Println("Number of slices: ")
+	fmt.Scanln(&numOfSlices)
+
 	if times <= 0 {
 		times = 1
 	}
+
+	if numOfSlices <= 1 {
+		numOfSlices = 2
+	}
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Println("interrupted, cleared scrolls: ", clear_tracked_scrolls())
+		os.Exit(1)
+	}()
+
+	openBefore := open_scroll_contexts(client)
+	fmt.Println("open scroll contexts before: ", openBefore)
+
+	var sampler sync.WaitGroup
+	var maxOpen int64
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sample_open_contexts(client, time.Second, stopSampling, &sampler, &maxOpen)
+
 	var done sync.WaitGroup
 	done.Add(times)
 
@@ -143,4 +307,33 @@ func main() {
 	fmt.Println("max read 1 page takes millis: ", maxTook)
 	fmt.Println("avg read total time millis: ", totalTime/int64(times))
 	fmt.Println("avg hits: ", totalHits/int64(times))
-}
\ No newline at end of file
+
+	// for sliced scroll
+	totalHits = 0
+	var totalElapsed time.Duration
+	for i := 0; i < times; i += 1 {
+		millis := time.Now().UnixNano() / 1e6
+		h, elapsed := scroll_visibility_sliced(0, millis, pageSize, numOfSlices)
+		totalHits += h
+		totalElapsed += elapsed
+	}
+	fmt.Println("------ Scroll Visibility Sliced ------")
+	fmt.Println("avg scan time: ", time.Duration(int64(totalElapsed)/int64(times)))
+	fmt.Println("avg hits: ", totalHits/int64(times))
+	if totalElapsed > 0 {
+		fmt.Println("docs/sec: ", float64(totalHits)/totalElapsed.Seconds())
+	}
+
+	close(stopSampling)
+	sampler.Wait()
+
+	fmt.Println("------ Scroll Contexts ------")
+	fmt.Println("left open by this run and cleared at exit: ", clear_tracked_scrolls())
+	openAfter := open_scroll_contexts(client)
+	fmt.Println("open scroll contexts before: ", openBefore)
+	fmt.Println("open scroll contexts peak: ", maxOpen)
+	fmt.Println("open scroll contexts after: ", openAfter)
+	if openAfter > openBefore {
+		fmt.Println("warning: ", openAfter-openBefore, " scroll contexts leaked")
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+type SortedWorkflow struct {
+	WorkflowID       string `json:"workflow_id"`
+	RunID            string `json:"run_id"`
+	WorkflowTypeName string `json:"workflow_type_name"`
+	Status           int    `json:"status"`
+	StartTime        int64  `json:"start_time"`
+	CloseTime        int64  `json:"close_time"`
+	HistoryLength    int    `json:"history_length"`
+	Info             string `json:"info,omitempty"`
+}
+
+const unsorted_index_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	},
+	"mappings":{
+		"_doc":{
+			"properties":{
+				"close_time":{
+					"type":"long"
+				}
+			}
+		}
+	}
+}`
+
+const sorted_index_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1,
+		"index":{
+			"sort.field": "close_time",
+			"sort.order": "desc"
+		}
+	},
+	"mappings":{
+		"_doc":{
+			"properties":{
+				"close_time":{
+					"type":"long"
+				}
+			}
+		}
+	}
+}`
+
+const unsortedDomainID = "unsorted-69f9-4495-a1b2-6ea71b5fa459"
+const sortedDomainID = "sortedcl-69f9-4495-a1b2-6ea71b5fa459"
+const sortedWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+func createSortedTestIndex(ctx context.Context, client *elastic.Client, index, setting string) bool {
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		return false
+	}
+	fmt.Println("create index ", index)
+	createIndex, err := client.CreateIndex(index).BodyString(setting).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !createIndex.Acknowledged {
+		// Not acknowledged
+	}
+	return true
+}
+
+// sortedLoadProgress is the status line of the load phase. Every bulk writes
+// each doc to both indices, so a bulk is 2*batchSize docs.
+type sortedLoadProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressSortedLoad = &sortedLoadProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *sortedLoadProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *sortedLoadProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *sortedLoadProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// loadSortedTestIndices writes the same closed workflows into both indices,
+// spread over the last numOfHours hours.
+func loadSortedTestIndices(threadID string, done *sync.WaitGroup, times, batchSize, numOfHours int) {
+	defer done.Done()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	for t := 1; t <= times; t++ {
+		bulkRequest := client.Bulk()
+		for i := 0; i < batchSize; i++ {
+			millis := time.Now().UnixNano()/1e6 - r.Int63n(int64(numOfHours)*3600000)
+			rid := uuid.New()
+
+			id := rid + "_" + rid
+			body := SortedWorkflow{
+				WorkflowID:       rid,
+				RunID:            rid,
+				WorkflowTypeName: sortedWorkflowTypeName,
+				Status:           0,
+				StartTime:        millis - 3600,
+				CloseTime:        millis,
+				HistoryLength:    1024,
+				Info:             "some info",
+			}
+
+			bulkRequest.Add(elastic.NewBulkIndexRequest().Index(unsortedDomainID).Type("_doc").Id(id).Doc(body))
+			bulkRequest.Add(elastic.NewBulkIndexRequest().Index(sortedDomainID).Type("_doc").Id(id).Doc(body))
+		}
+
+		reqStartTime := time.Now()
+		bulkResponse, err := bulkRequest.Do(context.Background())
+		if err != nil {
+			fmt.Println("bulk failed", err)
+			progressSortedLoad.record(time.Since(reqStartTime), 0, 2*batchSize)
+		} else {
+			failed := len(bulkResponse.Failed())
+			progressSortedLoad.record(time.Since(reqStartTime), 2*batchSize-failed, failed)
+		}
+	}
+}
+
+// readSortedVisibility runs the read_visibility.go list query. When
+// trackTotalHits is false the total hit count is not computed, which lets
+// a sorted index terminate early once a page worth of hits is collected.
+func readSortedVisibility(ctx context.Context, client *elastic.Client, index string,
+	low, high int64, from, pagesize int, trackTotalHits bool) (int64, int64, time.Duration) {
+	matchQuery := elastic.NewMatchQuery("workflow_type_name", sortedWorkflowTypeName)
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(low).Lte(high)
+	boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery)
+
+	source, err := elastic.NewSearchSource().Query(boolQuery).
+		Sort("close_time", false).
+		From(from).Size(pagesize).
+		Source()
+	if err != nil {
+		panic(err)
+	}
+	body := source.(map[string]interface{})
+	if !trackTotalHits {
+		body["track_total_hits"] = false
+	}
+
+	reqStartTime := time.Now()
+	searchResult, err := client.Search().Index(index).Source(body).Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return searchResult.TookInMillis, int64(len(searchResult.Hits.Hits)), time.Since(reqStartTime)
+}
+
+func runSortedReads(ctx context.Context, client *elastic.Client, name, index string, times, numOfHours int, trackTotalHits bool) {
+	var tooks []int64
+	var reqTimes []time.Duration
+	var totalHits int64
+	for i := 0; i < times; i++ {
+		millis := time.Now().UnixNano() / 1e6
+		src := rand.NewSource(millis)
+		r := rand.New(src)
+		took, h, reqTime := readSortedVisibility(ctx, client, index, millis-int64(numOfHours)*3600000, millis, r.Intn(10), 10, trackTotalHits)
+		tooks = append(tooks, took)
+		reqTimes = append(reqTimes, reqTime)
+		totalHits += h
+	}
+
+	sort.Slice(tooks, func(i, j int) bool { return tooks[i] < tooks[j] })
+	sort.Slice(reqTimes, func(i, j int) bool { return reqTimes[i] < reqTimes[j] })
+	var totalTook int64
+	for _, t := range tooks {
+		totalTook += t
+	}
+
+	fmt.Println("------ " + name + " ------")
+	fmt.Println("avg read took millis: ", totalTook/int64(times))
+	fmt.Println("p50 read took millis: ", tooks[(times-1)/2])
+	fmt.Println("p99 read took millis: ", tooks[(times-1)*99/100])
+	fmt.Println("p50 read request time: ", reqTimes[(times-1)/2])
+	fmt.Println("p99 read request time: ", reqTimes[(times-1)*99/100])
+	fmt.Println("avg hits per page: ", totalHits/int64(times))
+}
+
+func main() {
+	var numOfThread int
+	fmt.Println("Number of go routines to load data (0 to reuse existing data): ")
+	fmt.Scanln(&numOfThread)
+
+	var numOfRequestPerThread int
+	fmt.Println("Number of request per go routines: ")
+	fmt.Scanln(&numOfRequestPerThread)
+
+	var bulkSize int
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var times int
+	fmt.Println("Number of read requests: ")
+	fmt.Scanln(&times)
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 5000
+	}
+
+	if times <= 0 {
+		times = 100
+	}
+
+	numOfHours := 24
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	createdUnsorted := createSortedTestIndex(ctx, client, unsortedDomainID, unsorted_index_setting)
+	createdSorted := createSortedTestIndex(ctx, client, sortedDomainID, sorted_index_setting)
+	if createdUnsorted != createdSorted {
+		fmt.Println("warning: only one of the indices was just created, they will not hold the same data")
+	}
+
+	if numOfThread > 0 {
+		progressSortedLoad.start(int64(numOfThread * numOfRequestPerThread))
+		var progress sync.WaitGroup
+		stopProgress := make(chan struct{})
+		progress.Add(1)
+		go progressSortedLoad.show(stopProgress, &progress)
+		var done sync.WaitGroup
+		done.Add(numOfThread)
+		startTime := time.Now()
+		for i := 0; i < numOfThread; i += 1 {
+			go loadSortedTestIndices(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, numOfHours)
+		}
+		done.Wait()
+		close(stopProgress)
+		progress.Wait()
+		fmt.Println("load time: ", time.Since(startTime))
+	}
+
+	if _, err := client.Refresh(unsortedDomainID, sortedDomainID).Do(ctx); err != nil {
+		panic(err)
+	}
+
+	runSortedReads(ctx, client, "Unsorted Index", unsortedDomainID, times, numOfHours, true)
+	runSortedReads(ctx, client, "Unsorted Index (no total hits)", unsortedDomainID, times, numOfHours, false)
+	runSortedReads(ctx, client, "Sorted Index", sortedDomainID, times, numOfHours, true)
+	runSortedReads(ctx, client, "Sorted Index (early termination)", sortedDomainID, times, numOfHours, false)
+}
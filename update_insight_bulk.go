@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"time"
 
 	"encoding/json"
@@ -12,16 +15,36 @@ import (
 	"strconv"
 	"sync"
 	"hash/fnv"
+	"strings"
+	"sync/atomic"
 )
 
 const insight_bulk_update_setting = `
 {
 	"settings":{
 		"number_of_shards": 5,
-		"number_of_replicas": 1
-	}
+		"number_of_replicas": 1%s
+	}%s
 }`
 
+// all dynamic string fields as a single keyword field instead of text with a
+// keyword sub field, which halves the number of fields each state key adds
+const insight_bulk_update_keyword_mapping = `,
+	"mappings":{
+		"_doc":{
+			"dynamic_templates":[
+				{
+					"strings_as_keywords":{
+						"match_mapping_type": "string",
+						"mapping":{
+							"type": "keyword"
+						}
+					}
+				}
+			]
+		}
+	}`
+
 var stateKeys []string
 var stateValues [][]string
 var baseDocID string
@@ -29,30 +52,425 @@ var numOfStates int
 var numOfValues int
 var numOfDoc int
 var numOfStatesPerDoc int
+var totalFieldsLimit int
+var useKeywordMapping bool
+var mappingLimitRejected int64
+
+// updateBulkProgress is the status line of the bulk updates. A bulk that
+// fails as a whole counts all its items as errors before it is retried.
+type updateBulkProgress struct {
+	lock            sync.Mutex
+	tty             bool
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
 
-func updateInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
-	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
+var progressUpdateBulk = &updateBulkProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *updateBulkProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	info, err := os.Stdout.Stat()
+	p.tty = err == nil && info.Mode()&os.ModeCharDevice != 0
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+// log prints a line of its own for the field monitor. On a terminal it
+// clears the status line first, the next refresh draws it again below.
+func (p *updateBulkProgress) log(format string, args ...interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.tty {
+		fmt.Print("\r\033[K")
+	}
+	fmt.Printf(format, args...)
+}
+
+func (p *updateBulkProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *updateBulkProgress) show(stop chan struct{}, done *sync.WaitGroup) {
 	defer done.Done()
 
-	domainID := "bulkupda-843c-4055-8baa-de52d697335d"
+	interval := 10 * time.Second
+	if p.tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+
+		// printed under the lock so a field monitor line cannot land in the
+		// middle of it
+		if p.tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		p.lock.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+// updateBulkTimelinePoint is what one operation type did within one window of
+// the run, like TimelinePoint in insert_visibility_bulk.go.
+type updateBulkTimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	Ops        int64   `json:"ops"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Docs       int64   `json:"docs"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+type updateBulkTimelineBucket struct {
+	latencies []time.Duration
+	docs      int64
+	errors    int64
+}
+
+// updateBulkTimeline buckets every request by operation type and by the window
+// it finished in.
+type updateBulkTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*updateBulkTimelineBucket
+}
+
+var timelineUpdateBulk = &updateBulkTimeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *updateBulkTimeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*updateBulkTimelineBucket)
+}
+
+func (t *updateBulkTimeline) record(op string, latency time.Duration, docs, errors int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*updateBulkTimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &updateBulkTimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	b.docs += int64(docs)
+	b.errors += int64(errors)
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *updateBulkTimeline) points() []updateBulkTimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []updateBulkTimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := updateBulkTimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Docs = b.docs
+				p.DocsPerSec = float64(b.docs) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = updateBulkPercentileMs(b.latencies, 50)
+				p.P90Ms = updateBulkPercentileMs(b.latencies, 90)
+				p.P99Ms = updateBulkPercentileMs(b.latencies, 99)
+				p.MaxMs = updateBulkPercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func updateBulkPercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// updateBulkTelemetrySample is one sample of the cluster during the run, with
+// the fields of TelemetrySample in insert_visibility_bulk.go.
+type updateBulkTelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// updateBulkRunReport is written as JSON at the end of a run in the layout of
+// the insert_visibility_bulk.go run report, so report_html.go can show it.
+type updateBulkRunReport struct {
+	Workload   string                      `json:"workload"`
+	StartTime  time.Time                   `json:"start_time"`
+	Parameters map[string]interface{}      `json:"parameters"`
+	Telemetry  []updateBulkTelemetrySample `json:"telemetry"`
+	Timeline   []updateBulkTimelinePoint   `json:"timeline"`
+}
+
+// sampleUpdateBulkTelemetry reads node, cluster and index stats every interval
+// until stop is closed. Offsets are relative to startTime and indexing rate is
+// derived from the previous sample.
+func sampleUpdateBulkTelemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]updateBulkTelemetrySample) {
+	defer done.Done()
 
 	ctx := context.Background()
-	client, err := elastic.NewClient()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := updateBulkTelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func writeUpdateBulkReport(report updateBulkRunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
 		panic(err)
 	}
-	exists, err := client.IndexExists(domainID).Do(ctx)
-	if !exists {
-		fmt.Println("create index ", domainID)
-		createIndex, err := client.CreateIndex(domainID).BodyString(insight_bulk_update_setting).Do(ctx)
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+// waitForUpdateBulkQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForUpdateBulkQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		if !createIndex.Acknowledged {
-			// Not acknowledged
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
 		}
+		time.Sleep(time.Second)
 	}
+	return nil
+}
 
+func updateInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize int,
+	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
+	defer done.Done()
+
+	domainID := "bulkupda-843c-4055-8baa-de52d697335d"
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
 	bulkUsed := int64(0)
 	timeUsed := time.Duration(0)
 	startTime := time.Now()
@@ -88,6 +506,8 @@ func updateInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize i
 
 		bulkResponse, err := bulkRequest.Do(context.Background())
 		if err != nil {
+			progressUpdateBulk.record(time.Since(reqStartTime), 0, batchSize)
+			timelineUpdateBulk.record("bulk_update", time.Since(reqStartTime), 0, batchSize)
 			fmt.Println("bulk failed", err)
 			fmt.Println("remainning requeset: ", bulkRequest.NumberOfActions())
 			//panic("bulk failed")
@@ -96,21 +516,26 @@ func updateInsightBulk(threadID string, done *sync.WaitGroup, times, batchSize i
 			continue
 		}
 
-		timeUsed += time.Since(reqStartTime)
+		reqTime := time.Since(reqStartTime)
+		failed := len(bulkResponse.Failed())
+		progressUpdateBulk.record(reqTime, batchSize-failed, failed)
+		timelineUpdateBulk.record("bulk_update", reqTime, batchSize-failed, failed)
+		timeUsed += reqTime
 
 		if bulkRequest.NumberOfActions() != 0 {
 			fmt.Printf("bulk request not done %d\n", bulkRequest.NumberOfActions())
 		}
 
-		bulkUsed += int64(bulkResponse.Took)
-
-		if t%2000 == 0 {
-			fmt.Println(threadID, t)
+		for _, item := range bulkResponse.Failed() {
+			if item.Error != nil && strings.Contains(item.Error.Reason, "Limit of total fields") {
+				atomic.AddInt64(&mappingLimitRejected, 1)
+			}
 		}
+
+		bulkUsed += int64(bulkResponse.Took)
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 	*bulkTook += bulkUsed / int64(times)
 	*reqUsed += time.Duration(int64(timeUsed) / int64(times))
@@ -139,12 +564,108 @@ func main() {
 		numOfRequestPerThread = 10
 	}
 
+	var waitForStatus string
+	fmt.Println("Wait for health before start (green/yellow): ")
+	fmt.Scanln(&waitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before start (y/n): ")
+	fmt.Scanln(&waitForMerges)
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	fmt.Println("Total fields limit (0 for default 1000): ")
+	fmt.Scanln(&totalFieldsLimit)
+
+	var keywordMapping string
+	fmt.Println("Map dynamic strings as keyword only (y/n): ")
+	fmt.Scanln(&keywordMapping)
+	useKeywordMapping = keywordMapping == "y"
+
 	if bulkSize <= 0 {
 		bulkSize = 20000
 	}
 
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	if waitForStatus != "green" {
+		waitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+
 	initData()
 
+	if useKeywordMapping {
+		deleteForKeywordMapping("bulkupda-843c-4055-8baa-de52d697335d")
+	}
+
+	if totalFieldsLimit > 0 {
+		updateTotalFieldsLimit("bulkupda-843c-4055-8baa-de52d697335d")
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists("bulkupda-843c-4055-8baa-de52d697335d").Do(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if !exists {
+		fmt.Println("create index ", "bulkupda-843c-4055-8baa-de52d697335d")
+		createIndex, err := client.CreateIndex("bulkupda-843c-4055-8baa-de52d697335d").BodyString(insightBulkUpdateSetting()).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	err = waitForUpdateBulkQuiescence(client, []string{"bulkupda-843c-4055-8baa-de52d697335d"}, waitForStatus, waitForMerges == "y", time.Duration(preflightTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	stopMonitor := make(chan struct{})
+	var monitor sync.WaitGroup
+	monitor.Add(1)
+	go monitorFieldCount("bulkupda-843c-4055-8baa-de52d697335d", 10*time.Second, stopMonitor, &monitor)
+
+	runStartTime := time.Now()
+	timelineUpdateBulk.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	var telemetry []updateBulkTelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleUpdateBulkTelemetry(client, []string{"bulkupda-843c-4055-8baa-de52d697335d"}, runStartTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	progressUpdateBulk.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressUpdateBulk.show(stopProgress, &progress)
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -155,10 +676,159 @@ func main() {
 		go updateInsightBulk(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed)
 	}
 	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+	close(stopSampling)
+	sampler.Wait()
 	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
 	fmt.Println("avg time on request: ", time.Duration(int64(durationWithoutPrep)/int64(numOfThread)))
 	fmt.Println("avg bulk took: ", bulkTook/int64(numOfThread))
 	fmt.Println("avg req took: ", time.Duration(int64(reqUsed)/int64(numOfThread)))
+
+	close(stopMonitor)
+	monitor.Wait()
+	fmt.Println("updates rejected by total fields limit: ", atomic.LoadInt64(&mappingLimitRejected))
+
+	writeUpdateBulkReport(updateBulkRunReport{
+		Workload:  "update_insight_bulk",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"go_routines":          numOfThread,
+			"requests_per_routine": numOfRequestPerThread,
+			"bulk_size":            bulkSize,
+			"total_fields_limit":   totalFieldsLimit,
+			"keyword_mapping":      useKeywordMapping,
+			"wait_for_status":      waitForStatus,
+			"wait_for_merges":      waitForMerges == "y",
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Telemetry: telemetry,
+		Timeline:  timelineUpdateBulk.points(),
+	})
+}
+
+func insightBulkUpdateSetting() string {
+	limit := ""
+	if totalFieldsLimit > 0 {
+		limit = fmt.Sprintf(",\n\t\t\"index.mapping.total_fields.limit\": %d", totalFieldsLimit)
+	}
+	mapping := ""
+	if useKeywordMapping {
+		mapping = insight_bulk_update_keyword_mapping
+	}
+	return fmt.Sprintf(insight_bulk_update_setting, limit, mapping)
+}
+
+// deleteForKeywordMapping deletes an index left by an earlier run, so that it
+// is created again with the keyword mapping. Dynamic templates only apply to
+// fields added after they are set, so keeping the old index would measure the
+// field growth of the default mapping.
+func deleteForKeywordMapping(domainID string) {
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(domainID).Do(ctx)
+	if err != nil || !exists {
+		return
+	}
+	fmt.Println("warning: delete index ", domainID, " to recreate it with keyword mapping")
+	if _, err := client.DeleteIndex(domainID).Do(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// updateTotalFieldsLimit applies the limit to an index created by an earlier
+// run, new indices get it from insightBulkUpdateSetting.
+func updateTotalFieldsLimit(domainID string) {
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(domainID).Do(ctx)
+	if err != nil || !exists {
+		return
+	}
+	body := fmt.Sprintf(`{"index.mapping.total_fields.limit": %d}`, totalFieldsLimit)
+	if _, err := client.IndexPutSettings(domainID).BodyString(body).Do(ctx); err != nil {
+		fmt.Println("update total fields limit failed", err)
+	}
+}
+
+// monitorFieldCount reads the mapping of the index every interval and prints
+// how many fields it has and how fast that grows, until stop is closed.
+func monitorFieldCount(domainID string, interval time.Duration, stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	limit := totalFieldsLimit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	startTime := time.Now()
+	last := -1
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		mapping, err := client.GetMapping().Index(domainID).Do(context.Background())
+		if err != nil {
+			progressUpdateBulk.log("get mapping failed %v\n", err)
+			continue
+		}
+		count := 0
+		for _, index := range mapping {
+			types, _ := index.(map[string]interface{})["mappings"].(map[string]interface{})
+			for _, t := range types {
+				if properties, ok := t.(map[string]interface{})["properties"].(map[string]interface{}); ok {
+					count += countInsightFields(properties)
+				}
+			}
+		}
+
+		growth := 0
+		if last >= 0 {
+			growth = count - last
+		}
+		last = count
+		progressUpdateBulk.log("%v fields: %d (+%d) limit: %d\n", time.Since(startTime).Round(time.Second), count, growth, limit)
+		if count*10 >= limit*9 {
+			progressUpdateBulk.log("warning: field count is close to index.mapping.total_fields.limit\n")
+		}
+	}
+}
+
+// countInsightFields counts mapped fields, including multi fields such as the
+// keyword sub field of dynamic strings, the same way the fields limit does.
+func countInsightFields(properties map[string]interface{}) int {
+	count := 0
+	for _, v := range properties {
+		field, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		count++
+		if sub, ok := field["properties"].(map[string]interface{}); ok {
+			count += countInsightFields(sub)
+		}
+		if sub, ok := field["fields"].(map[string]interface{}); ok {
+			count += countInsightFields(sub)
+		}
+	}
+	return count
 }
 
 func initData() {
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"time"
 
 	"encoding/json"
@@ -30,6 +33,344 @@ var numOfValues2 int
 var numOfDoc2 int
 var numOfStatesPerDoc2 int
 
+// updateBulk2Progress is the status line of the doc per state bulk updates.
+type updateBulk2Progress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressUpdateBulk2 = &updateBulk2Progress{}
+
+// start resets the status line for a run of total requests.
+func (p *updateBulk2Progress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *updateBulk2Progress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *updateBulk2Progress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// updateBulk2TimelinePoint is what one operation type did within one window of
+// the run, like TimelinePoint in insert_visibility_bulk.go.
+type updateBulk2TimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	Ops        int64   `json:"ops"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Docs       int64   `json:"docs"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+type updateBulk2TimelineBucket struct {
+	latencies []time.Duration
+	docs      int64
+	errors    int64
+}
+
+// updateBulk2Timeline buckets every request by operation type and by the
+// window it finished in.
+type updateBulk2Timeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*updateBulk2TimelineBucket
+}
+
+var timelineUpdateBulk2 = &updateBulk2Timeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *updateBulk2Timeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*updateBulk2TimelineBucket)
+}
+
+func (t *updateBulk2Timeline) record(op string, latency time.Duration, docs, errors int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*updateBulk2TimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &updateBulk2TimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	b.docs += int64(docs)
+	b.errors += int64(errors)
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *updateBulk2Timeline) points() []updateBulk2TimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []updateBulk2TimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := updateBulk2TimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Docs = b.docs
+				p.DocsPerSec = float64(b.docs) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = updateBulk2PercentileMs(b.latencies, 50)
+				p.P90Ms = updateBulk2PercentileMs(b.latencies, 90)
+				p.P99Ms = updateBulk2PercentileMs(b.latencies, 99)
+				p.MaxMs = updateBulk2PercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func updateBulk2PercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// updateBulk2TelemetrySample is one sample of the cluster during the run, with
+// the fields of TelemetrySample in insert_visibility_bulk.go.
+type updateBulk2TelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// updateBulk2RunReport is written as JSON at the end of a run in the layout of
+// the insert_visibility_bulk.go run report, so report_html.go can show it.
+type updateBulk2RunReport struct {
+	Workload   string                       `json:"workload"`
+	StartTime  time.Time                    `json:"start_time"`
+	Parameters map[string]interface{}       `json:"parameters"`
+	Telemetry  []updateBulk2TelemetrySample `json:"telemetry"`
+	Timeline   []updateBulk2TimelinePoint   `json:"timeline"`
+}
+
+// sampleUpdateBulk2Telemetry reads node, cluster and index stats every
+// interval until stop is closed. Offsets are relative to startTime and
+// indexing rate is derived from the previous sample.
+func sampleUpdateBulk2Telemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]updateBulk2TelemetrySample) {
+	defer done.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := updateBulk2TelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func writeUpdateBulk2Report(report updateBulk2RunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
 func updateInsightBulk2(threadID string, done *sync.WaitGroup, times, batchSize int,
 	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
 	defer done.Done()
@@ -90,6 +431,8 @@ func updateInsightBulk2(threadID string, done *sync.WaitGroup, times, batchSize
 
 		bulkResponse, err := bulkRequest.Do(context.Background())
 		if err != nil {
+			progressUpdateBulk2.record(time.Since(reqStartTime), 0, batchSize)
+			timelineUpdateBulk2.record("bulk_update", time.Since(reqStartTime), 0, batchSize)
 			fmt.Println("bulk failed", err)
 			fmt.Println("remainning requeset: ", bulkRequest.NumberOfActions())
 			//panic("bulk failed")
@@ -98,21 +441,20 @@ func updateInsightBulk2(threadID string, done *sync.WaitGroup, times, batchSize
 			continue
 		}
 
-		timeUsed += time.Since(reqStartTime)
+		reqTime := time.Since(reqStartTime)
+		failed := len(bulkResponse.Failed())
+		progressUpdateBulk2.record(reqTime, batchSize-failed, failed)
+		timelineUpdateBulk2.record("bulk_update", reqTime, batchSize-failed, failed)
+		timeUsed += reqTime
 
 		if bulkRequest.NumberOfActions() != 0 {
 			fmt.Printf("bulk request not done %d\n", bulkRequest.NumberOfActions())
 		}
 
 		bulkUsed += int64(bulkResponse.Took)
-
-		if t%2000 == 0 {
-			fmt.Println(threadID, t)
-		}
 	}
 
 	elapsedTime := time.Since(startTime)
-	fmt.Println(threadID, elapsedTime)
 	*duration += elapsedTime
 	*bulkTook += bulkUsed / int64(times)
 	*reqUsed += time.Duration(int64(timeUsed) / int64(times))
@@ -133,6 +475,14 @@ func main() {
 	fmt.Println("Bulk size: ")
 	fmt.Scanln(&bulkSize)
 
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
 	if numOfThread <= 0 {
 		numOfThread = 1
 	}
@@ -145,8 +495,34 @@ func main() {
 		bulkSize = 20000
 	}
 
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
 	initData2()
 
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	runStartTime := time.Now()
+	timelineUpdateBulk2.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	var telemetry []updateBulk2TelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleUpdateBulk2Telemetry(client, []string{"bulkupd2-843c-4055-8baa-de52d697335d"}, runStartTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	progressUpdateBulk2.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressUpdateBulk2.show(stopProgress, &progress)
 	var done sync.WaitGroup
 	done.Add(numOfThread)
 	var duration time.Duration
@@ -157,10 +533,28 @@ func main() {
 		go updateInsightBulk2(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed)
 	}
 	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+	close(stopSampling)
+	sampler.Wait()
 	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
 	fmt.Println("avg time on request: ", time.Duration(int64(durationWithoutPrep)/int64(numOfThread)))
 	fmt.Println("avg bulk took: ", bulkTook/int64(numOfThread))
 	fmt.Println("avg req took: ", time.Duration(int64(reqUsed)/int64(numOfThread)))
+
+	writeUpdateBulk2Report(updateBulk2RunReport{
+		Workload:  "update_insight_bulk2",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"go_routines":          numOfThread,
+			"requests_per_routine": numOfRequestPerThread,
+			"bulk_size":            bulkSize,
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Telemetry: telemetry,
+		Timeline:  timelineUpdateBulk2.points(),
+	})
 }
 
 func initData2() {
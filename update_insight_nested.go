@@ -0,0 +1,694 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const insight_nested_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	},
+	"mappings":{
+		"_doc":{
+			"properties":{
+				"update_time":{
+					"type":"long"
+				},
+				"states":{
+					"type":"nested",
+					"properties":{
+						"key":{
+							"type":"keyword"
+						},
+						"value":{
+							"type":"keyword"
+						},
+						"update_time":{
+							"type":"long"
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// replaces the state with the same key in the nested array, or appends it
+const insight_nested_upsert_script = `
+boolean found = false;
+for (def s : ctx._source.states) {
+	if (s.key == params.key) {
+		s.value = params.value;
+		s.update_time = params.update_time;
+		found = true;
+		break;
+	}
+}
+if (!found) {
+	ctx._source.states.add(['key': params.key, 'value': params.value, 'update_time': params.update_time]);
+}
+ctx._source.update_time = params.update_time;
+`
+
+const nestedDomainID = "bulknest-843c-4055-8baa-de52d697335d"
+
+var stateKeysNested []string
+var stateValuesNested [][]string
+var baseDocIDNested string
+var numOfStatesNested int
+var numOfValuesNested int
+var numOfDocNested int
+var numOfStatesPerDocNested int
+
+// nestedProgress is the status line of the scripted nested bulk updates.
+type nestedProgress struct {
+	lock            sync.Mutex
+	startTime       time.Time
+	windowStart     time.Time
+	total           int64
+	requests        int64
+	errors          int64
+	windowDocs      int64
+	windowLatencies []time.Duration
+}
+
+var progressNested = &nestedProgress{}
+
+// start resets the status line for a run of total requests.
+func (p *nestedProgress) start(total int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.startTime = time.Now()
+	p.windowStart = p.startTime
+	p.total = total
+}
+
+func (p *nestedProgress) record(latency time.Duration, docs, errors int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.requests++
+	p.errors += int64(errors)
+	p.windowDocs += int64(docs)
+	p.windowLatencies = append(p.windowLatencies, latency)
+}
+
+// show refreshes the status line every second until stop is closed. When
+// stdout is not a terminal it logs the same line every 10 seconds instead.
+func (p *nestedProgress) show(stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	info, err := os.Stdout.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := 10 * time.Second
+	if tty {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		latencies := p.windowLatencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var p50, p99 time.Duration
+		if len(latencies) > 0 {
+			p50 = latencies[(len(latencies)-1)*50/100]
+			p99 = latencies[(len(latencies)-1)*99/100]
+		}
+		elapsed := time.Since(p.startTime)
+		// the last window is cut short by stop
+		window := time.Since(p.windowStart).Seconds()
+		eta := "-"
+		if p.requests > 0 && p.requests < p.total {
+			eta = time.Duration(float64(elapsed) * float64(p.total-p.requests) / float64(p.requests)).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("elapsed %v  requests %d/%d  ops/sec %.1f  docs/sec %.0f  p50 %v  p99 %v  errors %d  eta %s",
+			elapsed.Round(time.Second), p.requests, p.total, float64(len(latencies))/window,
+			float64(p.windowDocs)/window, p50.Round(time.Millisecond), p99.Round(time.Millisecond), p.errors, eta)
+		p.windowStart = time.Now()
+		p.windowDocs = 0
+		p.windowLatencies = nil
+		p.lock.Unlock()
+
+		if tty {
+			// \033[K clears what is left of a longer previous line
+			fmt.Print("\r", line, "\033[K")
+			if stopped {
+				fmt.Println()
+			}
+		} else {
+			fmt.Println(line)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// nestedTimelinePoint is what one operation type did within one window of the
+// run, like TimelinePoint in insert_visibility_bulk.go.
+type nestedTimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	Ops        int64   `json:"ops"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Docs       int64   `json:"docs"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+type nestedTimelineBucket struct {
+	latencies []time.Duration
+	docs      int64
+	errors    int64
+}
+
+// nestedTimeline buckets every request by operation type and by the window it
+// finished in.
+type nestedTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*nestedTimelineBucket
+}
+
+var timelineNested = &nestedTimeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *nestedTimeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*nestedTimelineBucket)
+}
+
+func (t *nestedTimeline) record(op string, latency time.Duration, docs, errors int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*nestedTimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &nestedTimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	b.docs += int64(docs)
+	b.errors += int64(errors)
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *nestedTimeline) points() []nestedTimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []nestedTimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := nestedTimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Docs = b.docs
+				p.DocsPerSec = float64(b.docs) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = nestedPercentileMs(b.latencies, 50)
+				p.P90Ms = nestedPercentileMs(b.latencies, 90)
+				p.P99Ms = nestedPercentileMs(b.latencies, 99)
+				p.MaxMs = nestedPercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func nestedPercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// nestedTelemetrySample is one sample of the cluster during the run, with the
+// fields of TelemetrySample in insert_visibility_bulk.go.
+type nestedTelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// nestedRunReport is written as JSON at the end of a run in the layout of the
+// insert_visibility_bulk.go run report, so report_html.go can show it.
+type nestedRunReport struct {
+	Workload   string                  `json:"workload"`
+	StartTime  time.Time               `json:"start_time"`
+	Parameters map[string]interface{}  `json:"parameters"`
+	Telemetry  []nestedTelemetrySample `json:"telemetry"`
+	Timeline   []nestedTimelinePoint   `json:"timeline"`
+}
+
+// sampleNestedTelemetry reads node, cluster and index stats every interval
+// until stop is closed. Offsets are relative to startTime and indexing rate is
+// derived from the previous sample.
+func sampleNestedTelemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]nestedTelemetrySample) {
+	defer done.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := nestedTelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func writeNestedReport(report nestedRunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+func updateInsightNested(threadID string, done *sync.WaitGroup, times, batchSize int,
+	duration, durationWithoutPrep *time.Duration, bulkTook *int64, reqUsed *time.Duration) {
+	defer done.Done()
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(nestedDomainID).Do(ctx)
+	if !exists {
+		fmt.Println("create index ", nestedDomainID)
+		createIndex, err := client.CreateIndex(nestedDomainID).BodyString(insight_nested_setting).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	bulkUsed := int64(0)
+	timeUsed := time.Duration(0)
+	startTime := time.Now()
+	retry := 0
+	for t := 1; t <= times && retry < 20; t++ {
+
+		bulkRequest := client.Bulk()
+		for i := 0; i < batchSize; i++ {
+			millis := time.Now().UnixNano() / 1e6
+			src := rand.NewSource(time.Now().UnixNano())
+			r := rand.New(src)
+
+			id := baseDocIDNested + strconv.Itoa(r.Intn(numOfDocNested))
+
+			keyIndex := getKeyIndexNested(id, r.Intn(numOfStatesPerDocNested))
+			k := stateKeysNested[keyIndex]
+			v := stateValuesNested[keyIndex][r.Intn(numOfValuesNested)]
+
+			state := map[string]interface{}{"key": k, "value": v, "update_time": millis}
+			script := elastic.NewScript(insight_nested_upsert_script).Params(state)
+			upsert := map[string]interface{}{"states": []interface{}{state}, "update_time": millis}
+
+			req := elastic.NewBulkUpdateRequest().Index(nestedDomainID).Type("_doc").Id(id).
+				Script(script).Upsert(upsert).RetryOnConflict(3)
+			bulkRequest.Add(req)
+		}
+
+		if bulkRequest.NumberOfActions() != batchSize {
+			fmt.Printf("warning: number of actions is %d\n", bulkRequest.NumberOfActions())
+		}
+
+		reqStartTime := time.Now()
+
+		bulkResponse, err := bulkRequest.Do(context.Background())
+		if err != nil {
+			progressNested.record(time.Since(reqStartTime), 0, batchSize)
+			timelineNested.record("bulk_update", time.Since(reqStartTime), 0, batchSize)
+			fmt.Println("bulk failed", err)
+			fmt.Println("remainning requeset: ", bulkRequest.NumberOfActions())
+			t--
+			retry++
+			continue
+		}
+
+		reqTime := time.Since(reqStartTime)
+		failed := len(bulkResponse.Failed())
+		progressNested.record(reqTime, batchSize-failed, failed)
+		timelineNested.record("bulk_update", reqTime, batchSize-failed, failed)
+		timeUsed += reqTime
+
+		if bulkRequest.NumberOfActions() != 0 {
+			fmt.Printf("bulk request not done %d\n", bulkRequest.NumberOfActions())
+		}
+
+		if failed := bulkResponse.Failed(); len(failed) > 0 {
+			fmt.Printf("bulk has %d failed items, first: %v\n", len(failed), failed[0].Error)
+		}
+
+		bulkUsed += int64(bulkResponse.Took)
+	}
+
+	elapsedTime := time.Since(startTime)
+	*duration += elapsedTime
+	*bulkTook += bulkUsed / int64(times)
+	*reqUsed += time.Duration(int64(timeUsed) / int64(times))
+	*durationWithoutPrep += timeUsed
+}
+
+// readInsightNested finds entities having a state with the given value that
+// was updated within [low, high], the nested equivalent of readInsight.
+func readInsightNested(client *elastic.Client, low, high int64, from, pagesize int, stateKey, stateValue string) (int64, int64) {
+	nestedQuery := elastic.NewNestedQuery("states", elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("states.key", stateKey)).
+		Filter(elastic.NewTermQuery("states.value", stateValue)).
+		Filter(elastic.NewRangeQuery("states.update_time").Gte(low).Lte(high)))
+
+	searchResult, err := client.Search().Index(nestedDomainID).Query(nestedQuery).
+		Sort("update_time", false).
+		From(from).Size(pagesize).
+		Do(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	return searchResult.TookInMillis, searchResult.TotalHits()
+}
+
+func main() {
+
+	var numOfThread int
+	fmt.Println("Number of go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	var numOfRequestPerThread int
+	fmt.Println("Number of request per go routines: ")
+	fmt.Scanln(&numOfRequestPerThread)
+
+	var bulkSize int //10-15MB would be better
+	fmt.Println("Bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var numOfReads int
+	fmt.Println("Number of read requests: ")
+	fmt.Scanln(&numOfReads)
+
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 20000
+	}
+
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	if numOfReads <= 0 {
+		numOfReads = 100
+	}
+
+	initDataNested()
+
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	runStartTime := time.Now()
+	timelineNested.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	var telemetry []nestedTelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleNestedTelemetry(client, []string{nestedDomainID}, runStartTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	progressNested.start(int64(numOfThread * numOfRequestPerThread))
+	var progress sync.WaitGroup
+	stopProgress := make(chan struct{})
+	progress.Add(1)
+	go progressNested.show(stopProgress, &progress)
+	var done sync.WaitGroup
+	done.Add(numOfThread)
+	var duration time.Duration
+	var durationWithoutPrep time.Duration
+	var reqUsed time.Duration
+	var bulkTook int64
+	for i := 0; i < numOfThread; i += 1 {
+		go updateInsightNested(strconv.Itoa(i), &done, numOfRequestPerThread, bulkSize, &duration, &durationWithoutPrep, &bulkTook, &reqUsed)
+	}
+	done.Wait()
+	close(stopProgress)
+	progress.Wait()
+	fmt.Println("avg time: ", time.Duration(int64(duration)/int64(numOfThread)))
+	fmt.Println("avg time on request: ", time.Duration(int64(durationWithoutPrep)/int64(numOfThread)))
+	fmt.Println("avg bulk took: ", bulkTook/int64(numOfThread))
+	fmt.Println("avg req took: ", time.Duration(int64(reqUsed)/int64(numOfThread)))
+
+	if _, err := client.Refresh(nestedDomainID).Do(context.Background()); err != nil {
+		panic(err)
+	}
+
+	var totalTime int64
+	var totalHits int64
+	var reqTimes []time.Duration
+	for i := 0; i < numOfReads; i += 1 {
+		millis := time.Now().UnixNano() / 1e6
+		src := rand.NewSource(millis)
+		r := rand.New(src)
+		id := baseDocIDNested + strconv.Itoa(r.Intn(numOfDocNested))
+		keyIndex := getKeyIndexNested(id, r.Intn(numOfStatesPerDocNested))
+		k := stateKeysNested[keyIndex]
+		v := stateValuesNested[keyIndex][r.Intn(numOfValuesNested)]
+
+		reqStartTime := time.Now()
+		t, h := readInsightNested(client, millis-3600000, millis, r.Intn(10), 10, k, v)
+		reqTimes = append(reqTimes, time.Since(reqStartTime))
+		timelineNested.record("nested_read", time.Since(reqStartTime), 0, 0)
+		totalTime += t
+		totalHits += h
+	}
+	sort.Slice(reqTimes, func(i, j int) bool { return reqTimes[i] < reqTimes[j] })
+
+	fmt.Println("------ Nested Read ------")
+	fmt.Println("avg read time millis: ", totalTime/int64(numOfReads))
+	fmt.Println("p99 read request time: ", reqTimes[(numOfReads-1)*99/100])
+	fmt.Println("avg hits: ", totalHits/int64(numOfReads))
+
+	close(stopSampling)
+	sampler.Wait()
+	writeNestedReport(nestedRunReport{
+		Workload:  "update_insight_nested",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"go_routines":          numOfThread,
+			"requests_per_routine": numOfRequestPerThread,
+			"bulk_size":            bulkSize,
+			"reads":                numOfReads,
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Telemetry: telemetry,
+		Timeline:  timelineNested.points(),
+	})
+}
+
+func initDataNested() {
+	baseDocIDNested = uuid.New()
+	baseDocIDNested = baseDocIDNested + "_" + reverseNested(baseDocIDNested) + "_"
+
+	numOfStatesPerDocNested = 50
+
+	numOfStatesNested = 5000
+	numOfValuesNested = 100
+	numOfDocNested = 1000000 // 1M
+	for i := 0; i < numOfStatesNested; i++ {
+		stateKeysNested = append(stateKeysNested, uuid.New())
+
+		var values []string
+		for j := 0; j < numOfValuesNested; j++ {
+			values = append(values, uuid.New())
+		}
+		stateValuesNested = append(stateValuesNested, values)
+	}
+}
+
+func reverseNested(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < len(r)/2; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func getKeyIndexNested(uid string, offset int) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(uid))
+	hash := h.Sum32()
+
+	n := uint32(numOfStatesNested)
+	return (hash%n + uint32(offset)) % n
+}
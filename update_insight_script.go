@@ -0,0 +1,676 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+const insight_script_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1
+	}
+}`
+
+// counts updates and transitions between states of an entity
+const insight_counter_script = `
+ctx._source.update_count += 1;
+String transition = ctx._source.current_state + '__' + params.state;
+if (ctx._source.transitions == null) {
+	ctx._source.transitions = [:];
+}
+if (ctx._source.transitions.containsKey(transition)) {
+	ctx._source.transitions[transition] += 1;
+} else {
+	ctx._source.transitions[transition] = 1;
+}
+ctx._source.current_state = params.state;
+ctx._source.update_time = params.update_time;
+`
+
+const scriptDomainID = "scriptcn-843c-4055-8baa-de52d697335d"
+const partialDocDomainID = "partialc-843c-4055-8baa-de52d697335d"
+
+// insightScriptMode is one way of writing the same state changes.
+type insightScriptMode struct {
+	name   string
+	index  string
+	script bool
+	bulk   bool
+}
+
+type insightScriptStats struct {
+	lock      sync.Mutex
+	updates   int64
+	conflicts int64
+	failed    int64
+	calls     int64
+	reqUsed   time.Duration
+}
+
+// add records the outcome of calls HTTP requests that took reqUsed in total.
+func (s *insightScriptStats) add(updates, conflicts, failed, calls int64, reqUsed time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.updates += updates
+	s.conflicts += conflicts
+	s.failed += failed
+	s.calls += calls
+	s.reqUsed += reqUsed
+}
+
+func insightCounterScript(state string, millis int64) *elastic.Script {
+	return elastic.NewScript(insight_counter_script).
+		Param("state", state).
+		Param("update_time", millis)
+}
+
+func insightCounterUpsert(state string, millis int64) map[string]interface{} {
+	return map[string]interface{}{
+		"update_count":  1,
+		"current_state": state,
+		"transitions":   map[string]interface{}{},
+		"update_time":   millis,
+	}
+}
+
+func updateInsightCounters(mode insightScriptMode, done *sync.WaitGroup, times, batchSize, numOfDoc, retryOnConflict int,
+	baseDocID string, states []string, stats *insightScriptStats) {
+	defer done.Done()
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+
+	src := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(src)
+	for t := 1; t <= times; t++ {
+		var updates, conflicts, failed, calls int64
+		timeUsed := time.Duration(0)
+
+		if mode.bulk {
+			bulkRequest := client.Bulk()
+			for i := 0; i < batchSize; i++ {
+				millis := time.Now().UnixNano() / 1e6
+				id := baseDocID + strconv.Itoa(r.Intn(numOfDoc))
+				state := states[r.Intn(len(states))]
+
+				req := elastic.NewBulkUpdateRequest().Index(mode.index).Type("_doc").Id(id).RetryOnConflict(retryOnConflict)
+				if mode.script {
+					req = req.Script(insightCounterScript(state, millis)).Upsert(insightCounterUpsert(state, millis))
+				} else {
+					req = req.Doc(map[string]interface{}{"current_state": state, "update_time": millis}).DocAsUpsert(true)
+				}
+				bulkRequest.Add(req)
+			}
+
+			reqStartTime := time.Now()
+			bulkResponse, err := bulkRequest.Do(ctx)
+			reqTime := time.Since(reqStartTime)
+			timeUsed += reqTime
+			calls++
+			if err != nil {
+				fmt.Println("bulk failed", err)
+				failed += int64(batchSize)
+			} else {
+				for _, item := range bulkResponse.Items {
+					for _, res := range item {
+						if res.Status >= 200 && res.Status < 300 {
+							updates++
+						} else if res.Status == 409 {
+							conflicts++
+						} else {
+							failed++
+						}
+					}
+				}
+			}
+			// conflicts are what the modes are compared on, not errors
+			timelineScript.record(mode.name, reqTime, int(updates), int(failed))
+		} else {
+			for i := 0; i < batchSize; i++ {
+				millis := time.Now().UnixNano() / 1e6
+				id := baseDocID + strconv.Itoa(r.Intn(numOfDoc))
+				state := states[r.Intn(len(states))]
+
+				update := client.Update().Index(mode.index).Type("_doc").Id(id).RetryOnConflict(retryOnConflict)
+				if mode.script {
+					update = update.Script(insightCounterScript(state, millis)).Upsert(insightCounterUpsert(state, millis))
+				} else {
+					update = update.Doc(map[string]interface{}{"current_state": state, "update_time": millis}).DocAsUpsert(true)
+				}
+
+				reqStartTime := time.Now()
+				_, err := update.Do(ctx)
+				reqTime := time.Since(reqStartTime)
+				timeUsed += reqTime
+				calls++
+				if err == nil {
+					updates++
+					timelineScript.record(mode.name, reqTime, 1, 0)
+				} else if elastic.IsConflict(err) {
+					conflicts++
+					timelineScript.record(mode.name, reqTime, 0, 0)
+				} else {
+					fmt.Println(err)
+					failed++
+					timelineScript.record(mode.name, reqTime, 0, 1)
+				}
+			}
+		}
+
+		stats.add(updates, conflicts, failed, calls, timeUsed)
+	}
+}
+
+// sumUpdateCount returns the sum of update_count over the index, which should
+// equal the number of acknowledged scripted updates.
+func sumUpdateCount(ctx context.Context, client *elastic.Client, index string) int64 {
+	searchResult, err := client.Search().Index(index).
+		Aggregation("updates", elastic.NewSumAggregation().Field("update_count")).
+		Size(0).
+		Do(ctx)
+	if err != nil {
+		fmt.Println("sum update count failed ", err)
+		return -1
+	}
+	if sum, found := searchResult.Aggregations.Sum("updates"); found && sum.Value != nil {
+		return int64(*sum.Value)
+	}
+	return 0
+}
+
+// scriptTimelinePoint is what one operation type did within one window of the
+// run, like TimelinePoint in insert_visibility_bulk.go.
+type scriptTimelinePoint struct {
+	OffsetSec  float64 `json:"offset_sec"`
+	Operation  string  `json:"operation"`
+	Ops        int64   `json:"ops"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	Docs       int64   `json:"docs"`
+	DocsPerSec float64 `json:"docs_per_sec"`
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+type scriptTimelineBucket struct {
+	latencies []time.Duration
+	docs      int64
+	errors    int64
+}
+
+// scriptTimeline buckets every request by operation type and by the window it
+// finished in.
+type scriptTimeline struct {
+	lock      sync.Mutex
+	startTime time.Time
+	window    time.Duration
+	buckets   map[string]map[int64]*scriptTimelineBucket
+}
+
+var timelineScript = &scriptTimeline{}
+
+// start resets the timeline for a run that starts at startTime.
+func (t *scriptTimeline) start(startTime time.Time, window time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.startTime = startTime
+	t.window = window
+	t.buckets = make(map[string]map[int64]*scriptTimelineBucket)
+}
+
+func (t *scriptTimeline) record(op string, latency time.Duration, docs, errors int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	byWindow, ok := t.buckets[op]
+	if !ok {
+		byWindow = make(map[int64]*scriptTimelineBucket)
+		t.buckets[op] = byWindow
+	}
+	w := int64(time.Since(t.startTime) / t.window)
+	b, ok := byWindow[w]
+	if !ok {
+		b = &scriptTimelineBucket{}
+		byWindow[w] = b
+	}
+	b.latencies = append(b.latencies, latency)
+	b.docs += int64(docs)
+	b.errors += int64(errors)
+}
+
+// points returns the timeline ordered by window then operation. Windows with
+// nothing finished are kept as zeros, they are the stalls to look for.
+func (t *scriptTimeline) points() []scriptTimelinePoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var ops []string
+	last := int64(-1)
+	for op, byWindow := range t.buckets {
+		ops = append(ops, op)
+		for w := range byWindow {
+			if w > last {
+				last = w
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	windowSec := t.window.Seconds()
+	var points []scriptTimelinePoint
+	for w := int64(0); w <= last; w++ {
+		for _, op := range ops {
+			p := scriptTimelinePoint{OffsetSec: float64(w) * windowSec, Operation: op}
+			if b, ok := t.buckets[op][w]; ok {
+				sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+				p.Ops = int64(len(b.latencies))
+				p.OpsPerSec = float64(p.Ops) / windowSec
+				p.Docs = b.docs
+				p.DocsPerSec = float64(b.docs) / windowSec
+				p.Errors = b.errors
+				p.P50Ms = scriptPercentileMs(b.latencies, 50)
+				p.P90Ms = scriptPercentileMs(b.latencies, 90)
+				p.P99Ms = scriptPercentileMs(b.latencies, 99)
+				p.MaxMs = scriptPercentileMs(b.latencies, 100)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func scriptPercentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// scriptTelemetrySample is one sample of the cluster during the run, with the
+// fields of TelemetrySample in insert_visibility_bulk.go.
+type scriptTelemetrySample struct {
+	OffsetSec       float64 `json:"offset_sec"`
+	ClusterStatus   string  `json:"cluster_status"`
+	IndexingRate    float64 `json:"indexing_rate"`
+	WriteQueue      int     `json:"write_queue"`
+	WriteRejected   int64   `json:"write_rejected"`
+	SearchQueue     int     `json:"search_queue"`
+	SearchRejected  int64   `json:"search_rejected"`
+	HeapUsedPercent int     `json:"heap_used_percent"`
+	GCCount         int64   `json:"gc_count"`
+	GCTimeMillis    int64   `json:"gc_time_millis"`
+	MergesCurrent   int64   `json:"merges_current"`
+	MergesTotal     int64   `json:"merges_total"`
+	RefreshTotal    int64   `json:"refresh_total"`
+	SegmentCount    int64   `json:"segment_count"`
+	IndexDocs       int64   `json:"index_docs"`
+}
+
+// scriptRunReport is written as JSON at the end of a run in the layout of the
+// insert_visibility_bulk.go run report, so report_html.go can show it.
+type scriptRunReport struct {
+	Workload   string                  `json:"workload"`
+	StartTime  time.Time               `json:"start_time"`
+	Parameters map[string]interface{}  `json:"parameters"`
+	Telemetry  []scriptTelemetrySample `json:"telemetry"`
+	Timeline   []scriptTimelinePoint   `json:"timeline"`
+}
+
+// sampleScriptTelemetry reads node, cluster and index stats every interval
+// until stop is closed. Offsets are relative to startTime and indexing rate is
+// derived from the previous sample.
+func sampleScriptTelemetry(client *elastic.Client, indices []string, startTime time.Time, interval time.Duration,
+	stop chan struct{}, done *sync.WaitGroup, samples *[]scriptTelemetrySample) {
+	defer done.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastIndexTotal := int64(-1)
+	lastTime := startTime
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample := scriptTelemetrySample{OffsetSec: now.Sub(startTime).Seconds()}
+
+		health, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			fmt.Println("cluster health failed", err)
+		} else {
+			sample.ClusterStatus = health.Status
+		}
+
+		nodes, err := client.NodesStats().Metric("indices", "thread_pool", "jvm").Do(ctx)
+		if err != nil {
+			fmt.Println("nodes stats failed", err)
+			continue
+		}
+		indexTotal := int64(0)
+		for _, node := range nodes.Nodes {
+			for name, pool := range node.ThreadPool {
+				// the write pool is called bulk before 6.3
+				if name == "write" || name == "bulk" {
+					sample.WriteQueue += pool.Queue
+					sample.WriteRejected += pool.Rejected
+				} else if name == "search" {
+					sample.SearchQueue += pool.Queue
+					sample.SearchRejected += pool.Rejected
+				}
+			}
+			if node.JVM != nil {
+				if node.JVM.Mem != nil && node.JVM.Mem.HeapUsedPercent > sample.HeapUsedPercent {
+					sample.HeapUsedPercent = node.JVM.Mem.HeapUsedPercent
+				}
+				if node.JVM.GC != nil {
+					for _, c := range node.JVM.GC.Collectors {
+						sample.GCCount += c.CollectionCount
+						sample.GCTimeMillis += c.CollectionTimeInMillis
+					}
+				}
+			}
+			if node.Indices != nil {
+				if node.Indices.Indexing != nil {
+					indexTotal += node.Indices.Indexing.IndexTotal
+				}
+				if node.Indices.Merges != nil {
+					sample.MergesCurrent += node.Indices.Merges.Current
+					sample.MergesTotal += node.Indices.Merges.Total
+				}
+				if node.Indices.Refresh != nil {
+					sample.RefreshTotal += node.Indices.Refresh.Total
+				}
+				if node.Indices.Segments != nil {
+					sample.SegmentCount += node.Indices.Segments.Count
+				}
+			}
+		}
+		if lastIndexTotal >= 0 {
+			sample.IndexingRate = float64(indexTotal-lastIndexTotal) / now.Sub(lastTime).Seconds()
+		}
+		lastIndexTotal = indexTotal
+		lastTime = now
+
+		stats, err := client.IndexStats(indices...).Metric("docs").Do(ctx)
+		if err != nil {
+			fmt.Println("index stats failed", err)
+		} else {
+			for _, s := range stats.Indices {
+				if s.Primaries != nil && s.Primaries.Docs != nil {
+					sample.IndexDocs += s.Primaries.Docs.Count
+				}
+			}
+		}
+
+		*samples = append(*samples, sample)
+	}
+}
+
+func writeScriptReport(report scriptRunReport) {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf("./%s-%d.json", report.Workload, report.StartTime.Unix())
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		fmt.Println("write report failed", err)
+		return
+	}
+	fmt.Println("report written to ", filename)
+}
+
+// waitForScriptQuiescence blocks until the indices reach status with no
+// relocating shards and, if waitForMerges is set, until no merges are running
+// on them.
+func waitForScriptQuiescence(client *elastic.Client, indices []string, status string, waitForMerges bool, timeout time.Duration) error {
+	ctx := context.Background()
+	startTime := time.Now()
+	deadline := startTime.Add(timeout)
+
+	health, err := client.ClusterHealth().Index(indices...).
+		WaitForStatus(status).
+		WaitForNoRelocatingShards(true).
+		Timeout(fmt.Sprintf("%dms", int64(timeout/time.Millisecond))).
+		Do(ctx)
+	if elastic.IsTimeout(err) {
+		// ES answers a timed out wait with 408 and the client drops the body,
+		// so read the health again without waiting to say what was missing
+		current, healthErr := client.ClusterHealth().Index(indices...).Do(ctx)
+		if healthErr != nil {
+			return fmt.Errorf("timed out waiting for %s health: %v", status, err)
+		}
+		return fmt.Errorf("timed out waiting for %s health, status %s, relocating %d, initializing %d",
+			status, current.Status, current.RelocatingShards, current.InitializingShards)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("index health ", health.Status, " after ", time.Since(startTime))
+
+	for waitForMerges {
+		stats, err := client.IndexStats(indices...).Metric("merge").Do(ctx)
+		if err != nil {
+			return err
+		}
+		merges := int64(0)
+		for _, index := range stats.Indices {
+			if index.Total != nil && index.Total.Merges != nil {
+				merges += index.Total.Merges.Current
+			}
+		}
+		if merges == 0 {
+			fmt.Println("no active merges after ", time.Since(startTime))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d active merges", merges)
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func main() {
+	var numOfThread int
+	fmt.Println("Number of go routines: ")
+	fmt.Scanln(&numOfThread)
+
+	var numOfRequestPerThread int
+	fmt.Println("Number of request per go routines: ")
+	fmt.Scanln(&numOfRequestPerThread)
+
+	var bulkSize int
+	fmt.Println("Bulk size (updates per request, done one by one for single mode): ")
+	fmt.Scanln(&bulkSize)
+
+	var numOfDoc int
+	fmt.Println("Number of docs (fewer docs means more conflicts): ")
+	fmt.Scanln(&numOfDoc)
+
+	var retryOnConflict int
+	fmt.Println("Retry on conflict: ")
+	fmt.Scanln(&retryOnConflict)
+
+	var waitForStatus string
+	fmt.Println("Wait for health before start (green/yellow): ")
+	fmt.Scanln(&waitForStatus)
+
+	var waitForMerges string
+	fmt.Println("Wait for no active merges before start (y/n): ")
+	fmt.Scanln(&waitForMerges)
+
+	var preflightTimeout int
+	fmt.Println("Preflight timeout seconds: ")
+	fmt.Scanln(&preflightTimeout)
+
+	var telemetryInterval int
+	fmt.Println("Telemetry sample interval seconds: ")
+	fmt.Scanln(&telemetryInterval)
+
+	var timelineWindow int
+	fmt.Println("Timeline window seconds: ")
+	fmt.Scanln(&timelineWindow)
+
+	if numOfThread <= 0 {
+		numOfThread = 1
+	}
+
+	if numOfRequestPerThread <= 0 {
+		numOfRequestPerThread = 10
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	if numOfDoc <= 0 {
+		numOfDoc = 1000
+	}
+
+	if telemetryInterval <= 0 {
+		telemetryInterval = 5
+	}
+
+	if timelineWindow <= 0 {
+		timelineWindow = 1
+	}
+
+	if waitForStatus != "green" {
+		waitForStatus = "yellow"
+	}
+
+	if preflightTimeout <= 0 {
+		preflightTimeout = 300
+	}
+
+	var states []string
+	for i := 0; i < 10; i++ {
+		states = append(states, "state_"+strconv.Itoa(i))
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	for _, index := range []string{scriptDomainID, partialDocDomainID} {
+		exists, err := client.IndexExists(index).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if exists {
+			// update_count is checked against this run only
+			fmt.Println("delete index ", index)
+			if _, err := client.DeleteIndex(index).Do(ctx); err != nil {
+				panic(err)
+			}
+		}
+		fmt.Println("create index ", index)
+		createIndex, err := client.CreateIndex(index).BodyString(insight_script_setting).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	}
+
+	err = waitForScriptQuiescence(client, []string{scriptDomainID, partialDocDomainID}, waitForStatus, waitForMerges == "y", time.Duration(preflightTimeout)*time.Second)
+	if err != nil {
+		fmt.Println("preflight failed", err)
+		panic(err)
+	}
+
+	modes := []insightScriptMode{
+		{name: "script single", index: scriptDomainID, script: true, bulk: false},
+		{name: "script bulk", index: scriptDomainID, script: true, bulk: true},
+		{name: "partial doc single", index: partialDocDomainID, script: false, bulk: false},
+		{name: "partial doc bulk", index: partialDocDomainID, script: false, bulk: true},
+	}
+
+	runStartTime := time.Now()
+	timelineScript.start(runStartTime, time.Duration(timelineWindow)*time.Second)
+	var telemetry []scriptTelemetrySample
+	var sampler sync.WaitGroup
+	stopSampling := make(chan struct{})
+	sampler.Add(1)
+	go sampleScriptTelemetry(client, []string{scriptDomainID, partialDocDomainID}, runStartTime, time.Duration(telemetryInterval)*time.Second, stopSampling, &sampler, &telemetry)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "mode\tupdates\tupdates/sec\tavg time per call\tconflicts\tconflict rate\tfailed\t")
+	var scriptUpdates int64
+	for _, mode := range modes {
+		baseDocID := uuid.New() + "_"
+		var stats insightScriptStats
+		var done sync.WaitGroup
+		done.Add(numOfThread)
+		startTime := time.Now()
+		for i := 0; i < numOfThread; i++ {
+			go updateInsightCounters(mode, &done, numOfRequestPerThread, bulkSize, numOfDoc, retryOnConflict, baseDocID, states, &stats)
+		}
+		done.Wait()
+		elapsedTime := time.Since(startTime)
+		fmt.Println(mode.name, elapsedTime)
+
+		if mode.script {
+			scriptUpdates += stats.updates
+		}
+		total := stats.updates + stats.conflicts + stats.failed
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%v\t%d\t%.2f%%\t%d\t\n",
+			mode.name, stats.updates, float64(stats.updates)/elapsedTime.Seconds(),
+			time.Duration(int64(stats.reqUsed)/stats.calls),
+			stats.conflicts, float64(stats.conflicts)/float64(total)*100, stats.failed)
+	}
+
+	close(stopSampling)
+	sampler.Wait()
+
+	if _, err := client.Refresh(scriptDomainID).Do(ctx); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("------ Insight Counters ------")
+	w.Flush()
+	fmt.Println("acknowledged script updates: ", scriptUpdates)
+	fmt.Println("sum of update_count: ", sumUpdateCount(ctx, client, scriptDomainID))
+
+	writeScriptReport(scriptRunReport{
+		Workload:  "update_insight_script",
+		StartTime: runStartTime,
+		Parameters: map[string]interface{}{
+			"go_routines":          numOfThread,
+			"requests_per_routine": numOfRequestPerThread,
+			"bulk_size":            bulkSize,
+			"docs":                 numOfDoc,
+			"retry_on_conflict":    retryOnConflict,
+			"wait_for_status":      waitForStatus,
+			"wait_for_merges":      waitForMerges == "y",
+			"telemetry_interval_s": telemetryInterval,
+			"timeline_window_s":    timelineWindow,
+		},
+		Telemetry: telemetry,
+		Timeline:  timelineScript.points(),
+	})
+}
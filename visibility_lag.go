@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pborman/uuid"
+)
+
+type LagWorkflow struct {
+	WorkflowID       string `json:"workflow_id"`
+	RunID            string `json:"run_id"`
+	WorkflowTypeName string `json:"workflow_type_name"`
+	Status           int    `json:"status"`
+	StartTime        int64  `json:"start_time"`
+	CloseTime        int64  `json:"close_time"`
+	HistoryLength    int    `json:"history_length"`
+	Info             string `json:"info,omitempty"`
+}
+
+const lag_index_setting = `
+{
+	"settings":{
+		"number_of_shards": 5,
+		"number_of_replicas": 1,
+		"refresh_interval": "%s"
+	}
+}`
+
+const lagDomainID = "lagprobe-69f9-4495-a1b2-6ea71b5fa459"
+const lagWorkflowTypeName = "code.uber.internal/devexp/cadence-bench/load/basic.stressWorkflowExecute"
+
+func newLagWorkflow(rid string) LagWorkflow {
+	millis := time.Now().UnixNano() / 1e6
+	return LagWorkflow{
+		WorkflowID:       rid,
+		RunID:            rid,
+		WorkflowTypeName: lagWorkflowTypeName,
+		Status:           0,
+		StartTime:        millis - 3600,
+		CloseTime:        millis,
+		HistoryLength:    1024,
+		Info:             "some info",
+	}
+}
+
+// lagBackgroundLoad keeps bulk inserting closed workflows until stop is closed.
+func lagBackgroundLoad(client *elastic.Client, batchSize int, stop chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		bulkRequest := client.Bulk()
+		for i := 0; i < batchSize; i++ {
+			rid := uuid.New()
+			req := elastic.NewBulkIndexRequest().Index(lagDomainID).Type("_doc").Id(rid + "_" + rid).Doc(newLagWorkflow(rid))
+			bulkRequest.Add(req)
+		}
+		if _, err := bulkRequest.Do(context.Background()); err != nil {
+			fmt.Println("bulk failed", err)
+		}
+	}
+}
+
+// probeVisibilityLag writes one marker workflow and polls a list query until
+// the marker shows up. It returns the write latency and the time between the
+// write being acknowledged and the marker being searchable.
+func probeVisibilityLag(ctx context.Context, client *elastic.Client, refresh string,
+	pollInterval, timeout time.Duration) (time.Duration, time.Duration, bool) {
+	rid := uuid.New()
+	id := rid + "_" + rid
+	body := newLagWorkflow(rid)
+
+	indexService := client.Index().Index(lagDomainID).Type("_doc").Id(id).BodyJson(body)
+	if refresh != "" {
+		indexService = indexService.Refresh(refresh)
+	}
+
+	writeStartTime := time.Now()
+	if _, err := indexService.Do(ctx); err != nil {
+		fmt.Println("marker write failed ", err)
+		return 0, 0, false
+	}
+	ackTime := time.Now()
+	writeTime := ackTime.Sub(writeStartTime)
+
+	matchQuery := elastic.NewMatchQuery("workflow_type_name", lagWorkflowTypeName)
+	rangeQuery := elastic.NewRangeQuery("close_time").Gte(body.CloseTime - 3600000).Lte(body.CloseTime)
+	idsQuery := elastic.NewIdsQuery("_doc").Ids(id)
+	boolQuery := elastic.NewBoolQuery().Must(matchQuery).Filter(rangeQuery, idsQuery)
+
+	for time.Since(ackTime) < timeout {
+		searchResult, err := client.Search().Index(lagDomainID).Query(boolQuery).
+			Sort("close_time", false).
+			From(0).Size(10).
+			Do(ctx)
+		if err != nil {
+			fmt.Println("search failed ", err)
+		} else if searchResult.TotalHits() > 0 {
+			return writeTime, time.Since(ackTime), true
+		}
+		time.Sleep(pollInterval)
+	}
+	return writeTime, timeout, false
+}
+
+func percentileDuration(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func printLagDistribution(name string, values []time.Duration) {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	fmt.Println(name+" p50: ", percentileDuration(values, 0.5),
+		" p90: ", percentileDuration(values, 0.9),
+		" p99: ", percentileDuration(values, 0.99),
+		" max: ", percentileDuration(values, 1))
+}
+
+func runLagProbes(ctx context.Context, client *elastic.Client, refresh string, times int) {
+	var writeTimes []time.Duration
+	var lags []time.Duration
+	timedOut := 0
+	for i := 0; i < times; i++ {
+		w, l, found := probeVisibilityLag(ctx, client, refresh, 10*time.Millisecond, 30*time.Second)
+		if !found {
+			timedOut++
+		}
+		writeTimes = append(writeTimes, w)
+		lags = append(lags, l)
+	}
+
+	name := refresh
+	if name == "" {
+		name = "default"
+	}
+	fmt.Println("------ Visibility Lag (refresh=" + name + ") ------")
+	fmt.Println("probes: ", times, " not visible within timeout: ", timedOut)
+	printLagDistribution("write", writeTimes)
+	printLagDistribution("lag", lags)
+}
+
+func main() {
+	var times int
+	fmt.Println("Number of probes per variant: ")
+	fmt.Scanln(&times)
+
+	var numOfThread int
+	fmt.Println("Number of background go routines (0 for no load): ")
+	fmt.Scanln(&numOfThread)
+
+	var bulkSize int
+	fmt.Println("Background bulk size: ")
+	fmt.Scanln(&bulkSize)
+
+	var refreshInterval string
+	fmt.Println("Index refresh_interval (e.g. 1s, 5s, 30s): ")
+	fmt.Scanln(&refreshInterval)
+
+	if times <= 0 {
+		times = 100
+	}
+
+	if numOfThread < 0 {
+		numOfThread = 0
+	}
+
+	if bulkSize <= 0 {
+		bulkSize = 1000
+	}
+
+	if refreshInterval == "" {
+		refreshInterval = "1s"
+	}
+
+	ctx := context.Background()
+	client, err := elastic.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	exists, err := client.IndexExists(lagDomainID).Do(ctx)
+	if !exists {
+		fmt.Println("create index ", lagDomainID)
+		createIndex, err := client.CreateIndex(lagDomainID).BodyString(fmt.Sprintf(lag_index_setting, refreshInterval)).Do(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if !createIndex.Acknowledged {
+			// Not acknowledged
+		}
+	} else {
+		fmt.Println("update refresh_interval ", refreshInterval)
+		body := fmt.Sprintf(`{"index":{"refresh_interval":"%s"}}`, refreshInterval)
+		if _, err := client.IndexPutSettings(lagDomainID).BodyString(body).Do(ctx); err != nil {
+			panic(err)
+		}
+	}
+
+	var done sync.WaitGroup
+	stop := make(chan struct{})
+	done.Add(numOfThread)
+	for i := 0; i < numOfThread; i++ {
+		go lagBackgroundLoad(client, bulkSize, stop, &done)
+	}
+
+	runLagProbes(ctx, client, "", times)
+	runLagProbes(ctx, client, "wait_for", times)
+
+	close(stop)
+	done.Wait()
+}